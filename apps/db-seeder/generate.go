@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Every row is derived from (seed, table, id) alone, so any row can be
+// regenerated on its own. That lets verify mode walk a branch in id order and
+// compare each row with what the seeder wrote, without keeping the dataset in
+// memory, and keeps orders consistent with the product prices they reference.
+
+var (
+	firstNames = []string{"Alice", "Bob", "Charlie", "Diana", "Eve", "Frank", "Grace", "Heidi", "Ivan", "Judy", "Mallory", "Niaj", "Olivia", "Peggy", "Rupert", "Sybil", "Trent", "Victor", "Walter", "Yasmin"}
+	lastNames  = []string{"Anderson", "Brown", "Clark", "Davis", "Evans", "Garcia", "Harris", "Jones", "King", "Lopez", "Miller", "Nguyen", "Patel", "Robinson", "Smith", "Taylor", "Walker", "Wilson", "Young", "Zhang"}
+	countries  = []string{"US", "US", "US", "GB", "DE", "FR", "IL", "IN", "BR", "JP"}
+	adjectives = []string{"Compact", "Deluxe", "Ergonomic", "Portable", "Rugged", "Silent", "Smart", "Ultra", "Wireless", "Vintage"}
+	nouns      = []string{"Laptop", "Mouse", "Keyboard", "Monitor", "Headset", "Webcam", "Dock", "Speaker", "Charger", "Tablet"}
+	categories = []string{"computers", "peripherals", "audio", "accessories", "displays"}
+
+	// statuses is weighted: most orders are completed.
+	statuses = []string{"completed", "completed", "completed", "completed", "pending", "pending", "shipped", "cancelled"}
+
+	epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+const (
+	saltUsers    = 0x75736572
+	saltProducts = 0x70726f64
+	saltOrders   = 0x6f726465
+)
+
+type user struct {
+	ID        int64
+	Name      string
+	Email     string
+	Age       int
+	Country   string
+	CreatedAt time.Time
+}
+
+type product struct {
+	ID         int64
+	Name       string
+	Category   string
+	PriceCents int64
+}
+
+type order struct {
+	ID          int64
+	UserID      int64
+	ProductID   int64
+	Quantity    int
+	AmountCents int64
+	Status      string
+	CreatedAt   time.Time
+}
+
+// Canonical lines are what checksums are computed over. Money is formatted
+// from cents and times in UTC seconds so every engine reads back the same text.
+
+func (u user) line() string {
+	return fmt.Sprintf("%d|%s|%s|%d|%s|%s", u.ID, u.Name, u.Email, u.Age, u.Country, u.CreatedAt.UTC().Format(time.RFC3339))
+}
+
+func (p product) line() string {
+	return fmt.Sprintf("%d|%s|%s|%s", p.ID, p.Name, p.Category, formatCents(p.PriceCents))
+}
+
+func (o order) line() string {
+	return fmt.Sprintf("%d|%d|%d|%d|%s|%s|%s", o.ID, o.UserID, o.ProductID, o.Quantity, formatCents(o.AmountCents), o.Status, o.CreatedAt.UTC().Format(time.RFC3339))
+}
+
+type generator struct {
+	seed  uint64
+	sizes sizes
+}
+
+type sizes struct {
+	Users    int64 `json:"users"`
+	Products int64 `json:"products"`
+	Orders   int64 `json:"orders"`
+}
+
+func (g generator) user(id int64) user {
+	r := g.rand(saltUsers, id)
+	first, last := pick(r, firstNames), pick(r, lastNames)
+	return user{
+		ID:        id,
+		Name:      first + " " + last,
+		Email:     fmt.Sprintf("%s.%s.%d@example.com", strings.ToLower(first), strings.ToLower(last), id),
+		Age:       14 + r.intn(67),
+		Country:   pick(r, countries),
+		CreatedAt: epoch.Add(time.Duration(r.intn(365*24*60)) * time.Minute),
+	}
+}
+
+func (g generator) product(id int64) product {
+	r := g.rand(saltProducts, id)
+	return product{
+		ID:         id,
+		Name:       fmt.Sprintf("%s %s %d", pick(r, adjectives), pick(r, nouns), id),
+		Category:   pick(r, categories),
+		PriceCents: 199 + int64(r.intn(19800)),
+	}
+}
+
+func (g generator) order(id int64) order {
+	r := g.rand(saltOrders, id)
+	userID := 1 + int64(r.intn(int(g.sizes.Users)))
+	productID := 1 + int64(r.intn(int(g.sizes.Products)))
+	quantity := 1 + r.intn(5)
+	// An order is never older than the user who placed it.
+	placed := g.user(userID).CreatedAt.Add(time.Duration(r.intn(90*24*60)) * time.Minute)
+	return order{
+		ID:          id,
+		UserID:      userID,
+		ProductID:   productID,
+		Quantity:    quantity,
+		AmountCents: g.product(productID).PriceCents * int64(quantity),
+		Status:      pick(r, statuses),
+		CreatedAt:   placed,
+	}
+}
+
+func (g generator) rand(salt uint64, id int64) *rowRand {
+	return &rowRand{state: g.seed ^ salt<<32 ^ uint64(id)*0x9e3779b97f4a7c15}
+}
+
+// rowRand is splitmix64: cheap to create per row, which math/rand is not.
+type rowRand struct {
+	state uint64
+}
+
+func (r *rowRand) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (r *rowRand) intn(n int) int {
+	return int(r.next() % uint64(n))
+}
+
+func pick(r *rowRand, items []string) string {
+	return items[r.intn(len(items))]
+}
+
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
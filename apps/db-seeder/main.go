@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// db-seeder fills a source database with a deterministic users / products /
+// orders dataset and writes a checksum manifest, or (SEED_MODE=verify) checks
+// a database - typically a branch reached through mirrord - against the same
+// dataset.
+//
+//	DATABASE_URL       postgres://, mysql://, sqlserver:// (or mssql://), mongodb://
+//	                   (or MYSQL_HOST/PORT/USERNAME/PASSWORD/DATABASE)
+//	SEED_ENGINE        postgres|mysql|mssql|mongodb, inferred from the URL scheme
+//	SEED_MODE          seed (default), verify or manifest (no database needed)
+//	SEED_SIZE          small|medium|large|xlarge; SEED_USERS/PRODUCTS/ORDERS override
+//	SEED_VALUE         PRNG seed (default 42); same seed and sizes, same rows
+//	SEED_BATCH         rows per insert batch (default 1000)
+//	SEED_MANIFEST      where the manifest is written (default seed-manifest.json)
+//	SEED_VERIFY        full|subset|empty, what verify expects the copy to contain
+//	SEED_VERIFY_WHERE  per-table predicates for subset, see verify.go
+//	SEED_ALLOW_ORPHANS report orders without their user/product instead of failing
+
+var presets = map[string]sizes{
+	"small":  {Users: 300, Products: 50, Orders: 1000},
+	"medium": {Users: 10000, Products: 500, Orders: 50000},
+	"large":  {Users: 100000, Products: 2000, Orders: 1000000},
+	"xlarge": {Users: 1000000, Products: 10000, Orders: 5000000},
+}
+
+type tableManifest struct {
+	Rows   int64  `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifest describes a generated dataset. filters holds row counts for the
+// predicates the branch scenarios copy with, so filtered copies have an
+// expected size too.
+type manifest struct {
+	Engine      string                   `json:"engine,omitempty"`
+	Seed        uint64                   `json:"seed"`
+	Sizes       sizes                    `json:"sizes"`
+	Tables      map[string]tableManifest `json:"tables"`
+	Filters     map[string]int64         `json:"filters"`
+	DurationMs  int64                    `json:"duration_ms,omitempty"`
+	RowsPerSec  int64                    `json:"rows_per_second,omitempty"`
+	GeneratedBy string                   `json:"generated_by"`
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+
+	mode := getEnv("SEED_MODE", "seed")
+	gen, err := generatorFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("db-seeder mode=%s seed=%d users=%d products=%d orders=%d",
+		mode, gen.seed, gen.sizes.Users, gen.sizes.Products, gen.sizes.Orders)
+
+	if mode == "manifest" {
+		m := buildManifest(gen)
+		writeManifest(m)
+		return
+	}
+
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		dbURL = mysqlParamsURL()
+	}
+	if dbURL == "" {
+		log.Fatal("DATABASE_URL environment variable is not set")
+	}
+	engine := os.Getenv("SEED_ENGINE")
+	if engine == "" {
+		engine = engineFromURL(dbURL)
+	}
+
+	ctx := context.Background()
+	st, err := openStore(ctx, engine, dbURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", engine, err)
+	}
+	defer st.close()
+
+	switch mode {
+	case "seed":
+		m, err := seed(ctx, st, gen)
+		if err != nil {
+			log.Fatalf("Seeding failed: %v", err)
+		}
+		m.Engine = engine
+		writeManifest(m)
+	case "verify":
+		report := verify(ctx, st, gen, getEnv("SEED_VERIFY", "full"))
+		report.Engine = engine
+		out, _ := encodeJSON(report)
+		os.Stdout.Write(out)
+		if !report.Passed {
+			st.close()
+			log.Println("Verification FAILED")
+			os.Exit(1)
+		}
+		log.Println("Verification passed")
+	default:
+		log.Fatalf("Unknown SEED_MODE %q (want seed, verify or manifest)", mode)
+	}
+}
+
+func generatorFromEnv() (generator, error) {
+	preset, ok := presets[getEnv("SEED_SIZE", "small")]
+	if !ok {
+		return generator{}, fmt.Errorf("unknown SEED_SIZE %q (want small, medium, large or xlarge)", os.Getenv("SEED_SIZE"))
+	}
+	seedValue, err := strconv.ParseUint(getEnv("SEED_VALUE", "42"), 10, 64)
+	if err != nil {
+		return generator{}, fmt.Errorf("SEED_VALUE must be an unsigned integer: %w", err)
+	}
+
+	s := preset
+	for key, dst := range map[string]*int64{"SEED_USERS": &s.Users, "SEED_PRODUCTS": &s.Products, "SEED_ORDERS": &s.Orders} {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 1 {
+				return generator{}, fmt.Errorf("%s must be a positive integer", key)
+			}
+			*dst = n
+		}
+	}
+	return generator{seed: seedValue, sizes: s}, nil
+}
+
+// mysqlParamsURL assembles a URL from MYSQL_HOST/PORT/USERNAME/PASSWORD/
+// DATABASE, the variables the mysql branch config patches instead of a URL.
+func mysqlParamsURL() string {
+	host := os.Getenv("MYSQL_HOST")
+	if host == "" {
+		return ""
+	}
+	u := url.URL{
+		Scheme: "mysql",
+		User:   url.UserPassword(os.Getenv("MYSQL_USERNAME"), os.Getenv("MYSQL_PASSWORD")),
+		Host:   net.JoinHostPort(host, getEnv("MYSQL_PORT", "3306")),
+		Path:   "/" + os.Getenv("MYSQL_DATABASE"),
+	}
+	return u.String()
+}
+
+func engineFromURL(dbURL string) string {
+	scheme, _, _ := strings.Cut(dbURL, "://")
+	switch scheme {
+	case "postgres", "postgresql":
+		return "postgres"
+	case "mysql":
+		return "mysql"
+	case "sqlserver", "mssql":
+		return "mssql"
+	case "mongodb", "mongodb+srv":
+		return "mongodb"
+	}
+	log.Fatalf("Cannot infer the engine from DATABASE_URL scheme %q; set SEED_ENGINE", scheme)
+	return ""
+}
+
+// seed recreates the three tables and inserts the dataset in id order. Users
+// and products go first so every order's foreign keys already exist.
+func seed(ctx context.Context, st store, gen generator) (*manifest, error) {
+	log.Println("Dropping and recreating users, products and orders...")
+	if err := st.reset(ctx); err != nil {
+		return nil, fmt.Errorf("reset tables: %w", err)
+	}
+
+	start := time.Now()
+	acc := newAccumulator(gen)
+	batch := getInt("SEED_BATCH", 1000)
+
+	users := make([]user, 0, batch)
+	for id := int64(1); id <= gen.sizes.Users; id++ {
+		u := gen.user(id)
+		acc.addUser(u)
+		if users = append(users, u); len(users) == batch || id == gen.sizes.Users {
+			if err := st.insertUsers(ctx, users); err != nil {
+				return nil, fmt.Errorf("insert users: %w", err)
+			}
+			users = users[:0]
+			progress("users", id, gen.sizes.Users)
+		}
+	}
+
+	products := make([]product, 0, batch)
+	for id := int64(1); id <= gen.sizes.Products; id++ {
+		p := gen.product(id)
+		acc.addProduct(p)
+		if products = append(products, p); len(products) == batch || id == gen.sizes.Products {
+			if err := st.insertProducts(ctx, products); err != nil {
+				return nil, fmt.Errorf("insert products: %w", err)
+			}
+			products = products[:0]
+			progress("products", id, gen.sizes.Products)
+		}
+	}
+
+	orders := make([]order, 0, batch)
+	for id := int64(1); id <= gen.sizes.Orders; id++ {
+		o := gen.order(id)
+		acc.addOrder(o)
+		if orders = append(orders, o); len(orders) == batch || id == gen.sizes.Orders {
+			if err := st.insertOrders(ctx, orders); err != nil {
+				return nil, fmt.Errorf("insert orders: %w", err)
+			}
+			orders = orders[:0]
+			progress("orders", id, gen.sizes.Orders)
+		}
+	}
+
+	m := acc.manifest()
+	elapsed := time.Since(start)
+	m.DurationMs = elapsed.Milliseconds()
+	total := gen.sizes.Users + gen.sizes.Products + gen.sizes.Orders
+	if secs := elapsed.Seconds(); secs > 0 {
+		m.RowsPerSec = int64(float64(total) / secs)
+	}
+	log.Printf("Seeded %d rows in %s", total, elapsed.Round(time.Millisecond))
+	return m, nil
+}
+
+// buildManifest computes the manifest without touching a database, e.g. to
+// check in next to a test or to compare with a seed run elsewhere.
+func buildManifest(gen generator) *manifest {
+	acc := newAccumulator(gen)
+	for id := int64(1); id <= gen.sizes.Users; id++ {
+		acc.addUser(gen.user(id))
+	}
+	for id := int64(1); id <= gen.sizes.Products; id++ {
+		acc.addProduct(gen.product(id))
+	}
+	for id := int64(1); id <= gen.sizes.Orders; id++ {
+		acc.addOrder(gen.order(id))
+	}
+	return acc.manifest()
+}
+
+// accumulator hashes canonical lines in id order and counts filter matches.
+type accumulator struct {
+	gen     generator
+	hashes  map[string]hash.Hash
+	rows    map[string]int64
+	filters map[string]int64
+}
+
+func newAccumulator(gen generator) *accumulator {
+	a := &accumulator{gen: gen, hashes: map[string]hash.Hash{}, rows: map[string]int64{}, filters: map[string]int64{}}
+	for _, t := range []string{"users", "products", "orders"} {
+		a.hashes[t] = sha256.New()
+	}
+	return a
+}
+
+func (a *accumulator) add(table, line string) {
+	a.rows[table]++
+	a.hashes[table].Write([]byte(line + "\n"))
+}
+
+func (a *accumulator) addUser(u user) {
+	a.add("users", u.line())
+	if u.Age >= 18 {
+		a.filters["users.age>=18"]++
+	}
+	if u.Age >= 24 {
+		a.filters["users.age>=24"]++
+	}
+}
+
+func (a *accumulator) addProduct(p product) {
+	a.add("products", p.line())
+	if p.PriceCents >= 10000 {
+		a.filters["products.price>=100"]++
+	}
+}
+
+func (a *accumulator) addOrder(o order) {
+	a.add("orders", o.line())
+	if o.AmountCents >= 5000 {
+		a.filters["orders.amount>=50"]++
+	}
+	if o.Status == "completed" {
+		a.filters["orders.status=completed"]++
+	}
+	// Orders that survive a users.age>=18 copy with referential integrity.
+	if a.gen.user(o.UserID).Age >= 18 {
+		a.filters["orders.user.age>=18"]++
+	}
+}
+
+func (a *accumulator) manifest() *manifest {
+	m := &manifest{
+		Seed:        a.gen.seed,
+		Sizes:       a.gen.sizes,
+		Tables:      map[string]tableManifest{},
+		Filters:     a.filters,
+		GeneratedBy: "db-seeder",
+	}
+	for t, h := range a.hashes {
+		m.Tables[t] = tableManifest{Rows: a.rows[t], SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+	return m
+}
+
+func writeManifest(m *manifest) {
+	out, err := encodeJSON(m)
+	if err != nil {
+		log.Fatalf("Failed to encode manifest: %v", err)
+	}
+	path := getEnv("SEED_MANIFEST", "seed-manifest.json")
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		log.Fatalf("Failed to write manifest: %v", err)
+	}
+	log.Printf("Wrote manifest to %s", path)
+	os.Stdout.Write(out)
+}
+
+// encodeJSON indents like MarshalIndent but leaves the >= in filter names
+// readable instead of escaping them.
+func encodeJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+func progress(table string, done, total int64) {
+	step := total / 10
+	if step == 0 || done%step == 0 || done == total {
+		log.Printf("  %s: %d/%d", table, done, total)
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Fatalf("%s must be a positive integer", key)
+	}
+	return n
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoDB keeps the same three collections with an integer id field next to
+// _id, so the branch filters in k8s/overlays/mongodb (users.age,
+// orders.amount) apply to seeded data unchanged. Money is stored as a double
+// like the existing sample documents and rounded back to cents on read.
+
+type mongoUser struct {
+	ID        int64     `bson:"id"`
+	Name      string    `bson:"name"`
+	Email     string    `bson:"email"`
+	Age       int       `bson:"age"`
+	Country   string    `bson:"country"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type mongoProduct struct {
+	ID       int64   `bson:"id"`
+	Name     string  `bson:"name"`
+	Category string  `bson:"category"`
+	Price    float64 `bson:"price"`
+}
+
+type mongoOrder struct {
+	ID        int64     `bson:"id"`
+	UserID    int64     `bson:"user_id"`
+	ProductID int64     `bson:"product_id"`
+	Quantity  int       `bson:"quantity"`
+	Amount    float64   `bson:"amount"`
+	Status    string    `bson:"status"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type mongoStore struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+func openMongoStore(ctx context.Context, uri string) (*mongoStore, error) {
+	connectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var client *mongo.Client
+	var err error
+	maxRetries := 10
+	for i := 0; i < maxRetries; i++ {
+		client, err = mongo.Connect(connectCtx, options.Client().ApplyURI(uri))
+		if err == nil {
+			if err = client.Ping(connectCtx, nil); err == nil {
+				break
+			}
+		}
+		if i < maxRetries-1 {
+			log.Printf("Waiting for database to be ready (attempt %d/%d): %v", i+1, maxRetries, err)
+			time.Sleep(3 * time.Second)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no answer after %d attempts: %w", maxRetries, err)
+	}
+
+	dbName := os.Getenv("MONGODB_DATABASE")
+	if dbName == "" {
+		dbName = "source_db"
+	}
+	log.Printf("Connected to MongoDB database %s", dbName)
+	return &mongoStore{client: client, db: client.Database(dbName)}, nil
+}
+
+func (s *mongoStore) close() error { return s.client.Disconnect(context.Background()) }
+
+func (s *mongoStore) reset(ctx context.Context) error {
+	for _, name := range []string{"orders", "products", "users"} {
+		if err := s.db.Collection(name).Drop(ctx); err != nil {
+			return fmt.Errorf("drop %s: %w", name, err)
+		}
+		if _, err := s.db.Collection(name).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}); err != nil {
+			return fmt.Errorf("index %s: %w", name, err)
+		}
+	}
+	_, err := s.db.Collection("orders").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "product_id", Value: 1}}},
+	})
+	return err
+}
+
+func (s *mongoStore) insertMany(ctx context.Context, name string, docs []any) error {
+	_, err := s.db.Collection(name).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+func (s *mongoStore) insertUsers(ctx context.Context, users []user) error {
+	docs := make([]any, len(users))
+	for i, u := range users {
+		docs[i] = mongoUser{ID: u.ID, Name: u.Name, Email: u.Email, Age: u.Age, Country: u.Country, CreatedAt: u.CreatedAt}
+	}
+	return s.insertMany(ctx, "users", docs)
+}
+
+func (s *mongoStore) insertProducts(ctx context.Context, products []product) error {
+	docs := make([]any, len(products))
+	for i, p := range products {
+		docs[i] = mongoProduct{ID: p.ID, Name: p.Name, Category: p.Category, Price: float64(p.PriceCents) / 100}
+	}
+	return s.insertMany(ctx, "products", docs)
+}
+
+func (s *mongoStore) insertOrders(ctx context.Context, orders []order) error {
+	docs := make([]any, len(orders))
+	for i, o := range orders {
+		docs[i] = mongoOrder{ID: o.ID, UserID: o.UserID, ProductID: o.ProductID, Quantity: o.Quantity,
+			Amount: float64(o.AmountCents) / 100, Status: o.Status, CreatedAt: o.CreatedAt}
+	}
+	return s.insertMany(ctx, "orders", docs)
+}
+
+func (s *mongoStore) scanUsers(ctx context.Context, fn func(user) error) error {
+	return scanCollection(ctx, s.db.Collection("users"), func(d mongoUser) error {
+		return fn(user{ID: d.ID, Name: d.Name, Email: d.Email, Age: d.Age, Country: d.Country, CreatedAt: d.CreatedAt})
+	})
+}
+
+func (s *mongoStore) scanProducts(ctx context.Context, fn func(product) error) error {
+	return scanCollection(ctx, s.db.Collection("products"), func(d mongoProduct) error {
+		return fn(product{ID: d.ID, Name: d.Name, Category: d.Category, PriceCents: toCents(d.Price)})
+	})
+}
+
+func (s *mongoStore) scanOrders(ctx context.Context, fn func(order) error) error {
+	return scanCollection(ctx, s.db.Collection("orders"), func(d mongoOrder) error {
+		return fn(order{ID: d.ID, UserID: d.UserID, ProductID: d.ProductID, Quantity: d.Quantity,
+			AmountCents: toCents(d.Amount), Status: d.Status, CreatedAt: d.CreatedAt})
+	})
+}
+
+func scanCollection[T any](ctx context.Context, coll *mongo.Collection, fn func(T) error) error {
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// orphans has no join to lean on, so it looks each order's parents up with
+// $lookup and counts the ones that came back empty.
+func (s *mongoStore) orphans(ctx context.Context) (map[string]int64, error) {
+	lookups := []struct{ name, from, field string }{
+		{"orders_without_user", "users", "user_id"},
+		{"orders_without_product", "products", "product_id"},
+	}
+	counts := map[string]int64{}
+	for _, l := range lookups {
+		name, from, field := l.name, l.from, l.field
+		pipeline := mongo.Pipeline{
+			{{Key: "$lookup", Value: bson.M{"from": from, "localField": field, "foreignField": "id", "as": "parent"}}},
+			{{Key: "$match", Value: bson.M{"parent": bson.M{"$size": 0}}}},
+			{{Key: "$count", Value: "n"}},
+		}
+		cursor, err := s.db.Collection("orders").Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		var result []struct {
+			N int64 `bson:"n"`
+		}
+		if err := cursor.All(ctx, &result); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		counts[name] = 0
+		if len(result) > 0 {
+			counts[name] = result[0].N
+		}
+	}
+	return counts, nil
+}
+
+func toCents(v float64) int64 {
+	return int64(math.Round(v * 100))
+}
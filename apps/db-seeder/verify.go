@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SEED_MODE=verify reads users, products and orders back in id order and
+// checks every row against the generator, so no copy of the dataset is needed
+// on the verifying side. SEED_VERIFY says what the copy should hold:
+//
+//	full    every seeded row, unchanged
+//	subset  only seeded rows, unchanged, with no order left without its user
+//	        or product; SEED_VERIFY_WHERE (e.g. "users.age>=24,orders.amount>=50")
+//	        also requires exactly the rows matching the predicate for that table
+//	empty   no rows at all
+//
+// Filters applied per table independently (the mongodb overlay copies users
+// by age and orders by amount) leave orders pointing at users that were not
+// copied; those fail as orphans unless SEED_ALLOW_ORPHANS=true.
+//
+// The same SEED_VALUE and sizes as the seed run must be used.
+
+const maxSamples = 5
+
+type mismatch struct {
+	ID       int64  `json:"id"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual"`
+}
+
+type tableReport struct {
+	Rows       int64      `json:"rows"`
+	Expected   *int64     `json:"expected_rows,omitempty"`
+	Matched    int64      `json:"matched"`
+	Mismatched int64      `json:"mismatched"`
+	Unexpected int64      `json:"unexpected"`
+	Missing    int64      `json:"missing"`
+	SHA256     string     `json:"sha256"`
+	Samples    []mismatch `json:"samples,omitempty"`
+}
+
+type verifyReport struct {
+	Engine     string                  `json:"engine"`
+	Mode       string                  `json:"mode"`
+	Where      []string                `json:"where,omitempty"`
+	Passed     bool                    `json:"passed"`
+	Seed       uint64                  `json:"seed"`
+	Sizes      sizes                   `json:"sizes"`
+	Tables     map[string]*tableReport `json:"tables"`
+	Orphans    map[string]int64        `json:"orphans,omitempty"`
+	Failures   []string                `json:"failures"`
+	DurationMs int64                   `json:"duration_ms"`
+}
+
+func (r *verifyReport) fail(format string, args ...any) {
+	r.Failures = append(r.Failures, fmt.Sprintf(format, args...))
+}
+
+// predicate is one SEED_VERIFY_WHERE term: table.field <op> number.
+type predicate struct {
+	table, field, op string
+	value            float64
+}
+
+var predicatePattern = regexp.MustCompile(`^(\w+)\.(\w+)\s*(>=|<=|!=|=|>|<)\s*(-?[\d.]+)$`)
+
+func parsePredicates(csv string) ([]predicate, error) {
+	var preds []predicate
+	for _, term := range splitCSV(csv) {
+		m := predicatePattern.FindStringSubmatch(term)
+		if m == nil {
+			return nil, fmt.Errorf("cannot parse SEED_VERIFY_WHERE term %q (want table.field>=number)", term)
+		}
+		v, _ := strconv.ParseFloat(m[4], 64)
+		p := predicate{table: m[1], field: m[2], op: m[3], value: v}
+		if _, ok := fieldValue(p.table, p.field, user{}, product{}, order{}); !ok {
+			return nil, fmt.Errorf("unknown field %s.%s in SEED_VERIFY_WHERE", p.table, p.field)
+		}
+		preds = append(preds, p)
+	}
+	return preds, nil
+}
+
+// fieldValue exposes the numeric columns a branch filter can reasonably use.
+func fieldValue(table, field string, u user, p product, o order) (float64, bool) {
+	switch table + "." + field {
+	case "users.id":
+		return float64(u.ID), true
+	case "users.age":
+		return float64(u.Age), true
+	case "products.id":
+		return float64(p.ID), true
+	case "products.price":
+		return float64(p.PriceCents) / 100, true
+	case "orders.id":
+		return float64(o.ID), true
+	case "orders.user_id":
+		return float64(o.UserID), true
+	case "orders.product_id":
+		return float64(o.ProductID), true
+	case "orders.quantity":
+		return float64(o.Quantity), true
+	case "orders.amount":
+		return float64(o.AmountCents) / 100, true
+	}
+	return 0, false
+}
+
+func (p predicate) holds(u user, pr product, o order) bool {
+	v, _ := fieldValue(p.table, p.field, u, pr, o)
+	switch p.op {
+	case ">=":
+		return v >= p.value
+	case "<=":
+		return v <= p.value
+	case ">":
+		return v > p.value
+	case "<":
+		return v < p.value
+	case "=":
+		return v == p.value
+	default:
+		return v != p.value
+	}
+}
+
+// tableCheck is one table's walk: what the generator says row id looks like
+// and, when the copy is constrained, whether row id belongs in it.
+type tableCheck struct {
+	total       int64
+	constrained bool
+	expected    func(id int64) (line string, keep bool)
+}
+
+func (c tableCheck) walk(scan func(fn func(id int64, line string) error) error) (*tableReport, error) {
+	r := &tableReport{}
+	h := sha256.New()
+	var kept int64
+	err := scan(func(id int64, line string) error {
+		r.Rows++
+		h.Write([]byte(line + "\n"))
+		if id < 1 || id > c.total {
+			r.Unexpected++
+			r.sample(mismatch{ID: id, Actual: line})
+			return nil
+		}
+		want, keep := c.expected(id)
+		switch {
+		case line != want:
+			r.Mismatched++
+			r.sample(mismatch{ID: id, Expected: want, Actual: line})
+		case c.constrained && !keep:
+			r.Unexpected++
+			r.sample(mismatch{ID: id, Actual: line})
+		default:
+			r.Matched++
+		}
+		if keep {
+			kept++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	if c.constrained {
+		var want int64
+		for id := int64(1); id <= c.total; id++ {
+			if _, keep := c.expected(id); keep {
+				want++
+			}
+		}
+		r.Expected = &want
+		r.Missing = want - kept
+	}
+	return r, nil
+}
+
+func (r *tableReport) sample(m mismatch) {
+	if len(r.Samples) < maxSamples {
+		r.Samples = append(r.Samples, m)
+	}
+}
+
+func verify(ctx context.Context, st store, gen generator, mode string) *verifyReport {
+	start := time.Now()
+	report := &verifyReport{
+		Mode:     mode,
+		Seed:     gen.seed,
+		Sizes:    gen.sizes,
+		Tables:   map[string]*tableReport{},
+		Failures: []string{},
+	}
+	if mode != "full" && mode != "subset" && mode != "empty" {
+		report.fail("unknown SEED_VERIFY %q (want full, subset or empty)", mode)
+		return report
+	}
+
+	preds, err := parsePredicates(os.Getenv("SEED_VERIFY_WHERE"))
+	if err != nil {
+		report.fail("%v", err)
+		return report
+	}
+	if len(preds) > 0 && mode != "subset" {
+		report.fail("SEED_VERIFY_WHERE only applies to SEED_VERIFY=subset")
+		return report
+	}
+	byTable := map[string][]predicate{}
+	for _, p := range preds {
+		byTable[p.table] = append(byTable[p.table], p)
+		report.Where = append(report.Where, fmt.Sprintf("%s.%s%s%g", p.table, p.field, p.op, p.value))
+	}
+	keepAll := func(table string, u user, p product, o order) bool {
+		for _, pred := range byTable[table] {
+			if !pred.holds(u, p, o) {
+				return false
+			}
+		}
+		return true
+	}
+	constrained := func(table string) bool {
+		return mode == "full" || len(byTable[table]) > 0
+	}
+
+	checks := []struct {
+		name  string
+		check tableCheck
+		scan  func(fn func(int64, string) error) error
+	}{
+		{
+			name: "users",
+			check: tableCheck{total: gen.sizes.Users, constrained: constrained("users"), expected: func(id int64) (string, bool) {
+				u := gen.user(id)
+				return u.line(), keepAll("users", u, product{}, order{})
+			}},
+			scan: func(fn func(int64, string) error) error {
+				return st.scanUsers(ctx, func(u user) error { return fn(u.ID, u.line()) })
+			},
+		},
+		{
+			name: "products",
+			check: tableCheck{total: gen.sizes.Products, constrained: constrained("products"), expected: func(id int64) (string, bool) {
+				p := gen.product(id)
+				return p.line(), keepAll("products", user{}, p, order{})
+			}},
+			scan: func(fn func(int64, string) error) error {
+				return st.scanProducts(ctx, func(p product) error { return fn(p.ID, p.line()) })
+			},
+		},
+		{
+			name: "orders",
+			check: tableCheck{total: gen.sizes.Orders, constrained: constrained("orders"), expected: func(id int64) (string, bool) {
+				o := gen.order(id)
+				return o.line(), keepAll("orders", user{}, product{}, o)
+			}},
+			scan: func(fn func(int64, string) error) error {
+				return st.scanOrders(ctx, func(o order) error { return fn(o.ID, o.line()) })
+			},
+		},
+	}
+
+	for _, c := range checks {
+		log.Printf("Checking %s...", c.name)
+		tr, err := c.check.walk(c.scan)
+		if err != nil {
+			report.fail("%s: %v", c.name, err)
+			continue
+		}
+		report.Tables[c.name] = tr
+
+		if mode == "empty" {
+			if tr.Rows != 0 {
+				report.fail("%s: expected no rows, found %d", c.name, tr.Rows)
+			}
+			continue
+		}
+		if tr.Mismatched > 0 {
+			report.fail("%s: %d rows differ from the seeded data", c.name, tr.Mismatched)
+		}
+		if tr.Unexpected > 0 {
+			report.fail("%s: %d rows should not be in the copy", c.name, tr.Unexpected)
+		}
+		if tr.Missing > 0 {
+			report.fail("%s: %d rows missing", c.name, tr.Missing)
+		}
+	}
+
+	if mode != "empty" {
+		orphans, err := st.orphans(ctx)
+		if err != nil {
+			report.fail("orphans: %v", err)
+		}
+		report.Orphans = orphans
+		allowOrphans := os.Getenv("SEED_ALLOW_ORPHANS") == "true"
+		for name, n := range orphans {
+			if n > 0 && !allowOrphans {
+				report.fail("%s: %d", name, n)
+			}
+		}
+	}
+
+	report.Passed = len(report.Failures) == 0
+	report.DurationMs = time.Since(start).Milliseconds()
+	return report
+}
+
+func splitCSV(csv string) []string {
+	var items []string
+	for _, s := range strings.Split(csv, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WATCH_FILES follows files next to the env vars, for operator patches that
+// arrive through config rather than the environment: a mounted
+// appsettings.json, or a ConfigMap or Secret volume. It is a comma separated
+// list of path=jsonpath+jsonpath entries; the JSON paths are optional:
+//
+//	WATCH_FILES='/app/config/appsettings.json=Service.ServiceName+ServiceBus.Topics[0],/app/secrets'
+//
+// Each path is reported as a sha256 of its content (unset while the file is
+// missing). A directory, such as a ConfigMap or Secret mount, hashes all of
+// its visible files together, so adding, removing or editing any key changes
+// it. Each JSON path is reported as path#jsonpath with the value it resolves
+// to: strings as they are, anything else as compact JSON, unset when the file
+// is not JSON or the path does not resolve.
+//
+// Files are polled on the same loop as the env vars rather than watched with
+// inotify: kubelet updates ConfigMap and Secret volumes by swapping a
+// symlinked ..data directory, which a poll that re-reads the path always sees.
+
+type fileWatch struct {
+	path      string
+	jsonPaths []string
+}
+
+func parseWatchFiles(raw string) ([]fileWatch, error) {
+	var out []fileWatch
+	index := map[string]int{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, pathList, hasPaths := strings.Cut(entry, "=")
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return nil, fmt.Errorf("WATCH_FILES entry %q has no path", entry)
+		}
+		var jsonPaths []string
+		if hasPaths {
+			for _, jp := range strings.Split(pathList, "+") {
+				jp = strings.TrimPrefix(strings.TrimSpace(jp), "$.")
+				if jp == "" {
+					continue
+				}
+				if _, err := splitJSONPath(jp); err != nil {
+					return nil, fmt.Errorf("WATCH_FILES entry %q: %w", entry, err)
+				}
+				jsonPaths = append(jsonPaths, jp)
+			}
+		}
+		if i, ok := index[path]; ok {
+			out[i].jsonPaths = append(out[i].jsonPaths, jsonPaths...)
+			continue
+		}
+		index[path] = len(out)
+		out = append(out, fileWatch{path: path, jsonPaths: jsonPaths})
+	}
+	return out, nil
+}
+
+// read returns the content hash of the file (or directory) and the value of
+// every JSON path, keyed by the names the probe reports them under.
+func (f fileWatch) read() map[string]*string {
+	values := map[string]*string{f.path: nil}
+	for _, jp := range f.jsonPaths {
+		values[f.path+"#"+jp] = nil
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return values
+	}
+	if info.IsDir() {
+		if sum, err := hashDir(f.path); err == nil {
+			values[f.path] = &sum
+		}
+		return values
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return values
+	}
+	sum := hashBytes(data)
+	values[f.path] = &sum
+
+	if len(f.jsonPaths) == 0 {
+		return values
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return values
+	}
+	for _, jp := range f.jsonPaths {
+		values[f.path+"#"+jp] = jsonValue(doc, jp)
+	}
+	return values
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// hashDir hashes the visible files of dir in name order. The dot entries
+// (..data and the timestamped directory behind it) are kubelet's plumbing and
+// are skipped; the key symlinks resolve through them.
+func hashDir(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%s\x00%x\n", name, sum)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// splitJSONPath splits a dotted path with optional [n] indexes, such as
+// ServiceBus.Topics[0].Name, into keys and int indexes.
+func splitJSONPath(jp string) ([]interface{}, error) {
+	var out []interface{}
+	for _, part := range strings.Split(jp, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key == "" && rest == "" {
+			return nil, fmt.Errorf("empty segment in JSON path %q", jp)
+		}
+		if key != "" {
+			out = append(out, key)
+		}
+		for rest != "" {
+			num, tail, ok := strings.Cut(rest, "]")
+			n, err := strconv.Atoi(num)
+			if !ok || err != nil || n < 0 {
+				return nil, fmt.Errorf("bad index in JSON path %q", jp)
+			}
+			out = append(out, n)
+			if tail == "" {
+				break
+			}
+			if !strings.HasPrefix(tail, "[") {
+				return nil, fmt.Errorf("bad index in JSON path %q", jp)
+			}
+			rest = tail[1:]
+		}
+	}
+	return out, nil
+}
+
+func jsonValue(doc interface{}, jp string) *string {
+	segments, err := splitJSONPath(jp)
+	if err != nil {
+		return nil
+	}
+	cur := doc
+	for _, seg := range segments {
+		switch seg := seg.(type) {
+		case string:
+			obj, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			if cur, ok = obj[seg]; !ok {
+				return nil
+			}
+		case int:
+			arr, ok := cur.([]interface{})
+			if !ok || seg >= len(arr) {
+				return nil
+			}
+			cur = arr[seg]
+		}
+	}
+	if s, ok := cur.(string); ok {
+		return &s
+	}
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return nil
+	}
+	s := string(data)
+	return &s
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"net/http"
 	"os"
 	"sort"
 	"strings"
@@ -17,6 +19,12 @@ import (
 // gives the operator the name through `fallback`, and the operator is expected
 // to add the env var when it patches the workload. Run this as the target and
 // you can see the variable go from <unset> to the operator's rewritten value.
+//
+// Every transition is also printed as one JSON line and kept for the HTTP API
+// on PORT (default 8080): /env has the current values, /events the
+// transitions, and /wait blocks until a variable reaches a value, so a test
+// can wait for a patch instead of grepping the loop output. WATCH_FILES adds
+// files to the same loop (see files.go), for the appsettings.json case itself.
 func main() {
 	// Comma-separated names to follow. Defaults to the variables used by the
 	// sample split config in this module.
@@ -25,6 +33,10 @@ func main() {
 		watch = "Service__ServiceName,SERVICEBUS_SUBSCRIPTION_NAME,SERVICEBUS_TOPIC_NAME,TOPIC"
 	}
 	names := strings.Split(watch, ",")
+	files, err := parseWatchFiles(os.Getenv("WATCH_FILES"))
+	if err != nil {
+		log.Fatalf("Invalid WATCH_FILES: %v", err)
+	}
 
 	// Dump everything once so the starting state is on the record before any
 	// patch happens.
@@ -34,24 +46,33 @@ func main() {
 	for _, kv := range startup {
 		fmt.Println(kv)
 	}
+	probe := newProbe(names, files)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	go func() {
+		if err := http.ListenAndServe(":"+port, newServer(probe)); err != nil {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
 	fmt.Printf("=== watching: %s ===\n", watch)
+	for _, f := range files {
+		if len(f.jsonPaths) > 0 {
+			fmt.Printf("=== watching file: %s (%s) ===\n", f.path, strings.Join(f.jsonPaths, ", "))
+		} else {
+			fmt.Printf("=== watching file: %s ===\n", f.path)
+		}
+	}
+	fmt.Printf("=== HTTP API on :%s (/env, /events, /wait) ===\n", port)
 	fmt.Println("READY")
 
 	for {
+		probe.sample()
 		now := time.Now().Format("15:04:05")
-		var parts []string
-		for _, name := range names {
-			name = strings.TrimSpace(name)
-			if name == "" {
-				continue
-			}
-			if value, ok := os.LookupEnv(name); ok {
-				parts = append(parts, fmt.Sprintf("%s=%q", name, value))
-			} else {
-				parts = append(parts, fmt.Sprintf("%s=<unset>", name))
-			}
-		}
-		fmt.Printf("[%s] %s\n", now, strings.Join(parts, "  "))
+		fmt.Printf("[%s] %s\n", now, probe.line())
 		time.Sleep(3 * time.Second)
 	}
 }
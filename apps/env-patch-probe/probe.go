@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of watched variable. A file's value is its content hash and a
+// file_json variable, named path#jsonpath, is a value inside a JSON file.
+const (
+	kindEnv      = "env"
+	kindFile     = "file"
+	kindFileJSON = "file_json"
+)
+
+// varState is the last sampled value of one watched variable. Value is nil
+// while the variable is unset (or the file is missing).
+type varState struct {
+	Variable string    `json:"variable"`
+	Kind     string    `json:"kind"`
+	Value    *string   `json:"value"`
+	Since    time.Time `json:"since"`
+}
+
+// changeEvent is one transition of a watched variable. Old or New is nil when
+// the variable was or became unset.
+type changeEvent struct {
+	Seq      int       `json:"seq"`
+	Event    string    `json:"event"`
+	Variable string    `json:"variable"`
+	Old      *string   `json:"old"`
+	New      *string   `json:"new"`
+	Time     time.Time `json:"time"`
+}
+
+// probe keeps the current value of every watched variable and the list of
+// transitions seen since startup. Waiters block on changed, which is closed
+// and replaced after every sample that recorded a transition.
+type probe struct {
+	mu      sync.Mutex
+	names   []string
+	kinds   map[string]string
+	envs    []string
+	files   []fileWatch
+	current map[string]varState
+	events  []changeEvent
+	changed chan struct{}
+	started time.Time
+}
+
+func newProbe(envs []string, files []fileWatch) *probe {
+	p := &probe{
+		kinds:   map[string]string{},
+		files:   files,
+		current: map[string]varState{},
+		changed: make(chan struct{}),
+		started: time.Now(),
+	}
+	for _, name := range envs {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p.envs = append(p.envs, name)
+		p.add(name, kindEnv)
+	}
+	for _, f := range files {
+		p.add(f.path, kindFile)
+		for _, jp := range f.jsonPaths {
+			p.add(f.path+"#"+jp, kindFileJSON)
+		}
+	}
+
+	values := p.read()
+	for _, name := range p.names {
+		p.current[name] = varState{Variable: name, Kind: p.kinds[name], Value: values[name], Since: p.started}
+	}
+	return p
+}
+
+func (p *probe) add(name, kind string) {
+	if _, ok := p.kinds[name]; ok {
+		return
+	}
+	p.kinds[name] = kind
+	p.names = append(p.names, name)
+}
+
+// read samples every watched env var and file, each file read once.
+func (p *probe) read() map[string]*string {
+	values := map[string]*string{}
+	for _, name := range p.envs {
+		if value, ok := os.LookupEnv(name); ok {
+			values[name] = &value
+		}
+	}
+	for _, f := range p.files {
+		for name, value := range f.read() {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// sample reads every watched variable again, records a transition for each
+// one that changed and prints it as a single JSON line.
+func (p *probe) sample() {
+	values := p.read()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var recorded bool
+	for _, name := range p.names {
+		prev := p.current[name]
+		value := values[name]
+		if sameValue(prev.Value, value) {
+			continue
+		}
+		p.current[name] = varState{Variable: name, Kind: prev.Kind, Value: value, Since: now}
+		ev := changeEvent{
+			Seq:      len(p.events) + 1,
+			Event:    prev.Kind + "_change",
+			Variable: name,
+			Old:      prev.Value,
+			New:      value,
+			Time:     now,
+		}
+		p.events = append(p.events, ev)
+		line, _ := json.Marshal(ev)
+		fmt.Println(string(line))
+		recorded = true
+	}
+	if recorded {
+		close(p.changed)
+		p.changed = make(chan struct{})
+	}
+}
+
+// line renders the watched variables the way the probe has always printed
+// them, so the 3s log loop reads the same as before. File hashes are cut to
+// 12 hex digits, enough to spot a change.
+func (p *probe) line() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	parts := make([]string, 0, len(p.names))
+	for _, name := range p.names {
+		st := p.current[name]
+		switch {
+		case st.Value == nil:
+			parts = append(parts, fmt.Sprintf("%s=<unset>", name))
+		case st.Kind == kindFile && len(*st.Value) > 19:
+			parts = append(parts, fmt.Sprintf("%s=%s", name, (*st.Value)[:19]))
+		default:
+			parts = append(parts, fmt.Sprintf("%s=%q", name, *st.Value))
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+// snapshot returns the current state of every watched variable of kind (all
+// of them when kind is empty), env vars first in WATCH_ENV order, then files.
+func (p *probe) snapshot(kind string) []varState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]varState, 0, len(p.names))
+	for _, name := range p.names {
+		if st := p.current[name]; kind == "" || st.Kind == kind {
+			out = append(out, st)
+		}
+	}
+	return out
+}
+
+// eventsSince returns the transitions with a sequence number above since.
+func (p *probe) eventsSince(since int) []changeEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if since < 0 {
+		since = 0
+	}
+	if since >= len(p.events) {
+		return []changeEvent{}
+	}
+	return append([]changeEvent(nil), p.events[since:]...)
+}
+
+// state returns one variable's current state and a channel that is closed on
+// the next recorded transition.
+func (p *probe) state(name string) (varState, <-chan struct{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st, ok := p.current[name]
+	return st, p.changed, ok
+}
+
+func sameValue(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
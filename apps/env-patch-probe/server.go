@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxWait caps /wait so a forgotten request does not hang a port-forward or
+// API server proxy connection forever.
+const maxWait = 10 * time.Minute
+
+type server struct {
+	probe *probe
+}
+
+// newServer exposes the probe over HTTP so tests can read the watched values
+// and block until a patch lands, instead of scraping the 3s log loop.
+func newServer(p *probe) http.Handler {
+	s := &server{probe: p}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/env", s.handleEnv)
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/wait", s.handleWait)
+	return mux
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"service": "env-patch-probe",
+		"endpoints": []string{
+			"GET /health",
+			"GET /env?kind=env|file|file_json",
+			"GET /events?since={seq}",
+			"GET /wait?var={name}&value={value}&timeout={duration}",
+			"GET /wait?var={name}&state=set|unset&timeout={duration}",
+		},
+	})
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleEnv returns the current value of every watched variable, or only
+// those of one kind. Files are variables too: a file's value is its content
+// hash and path#jsonpath is a value inside it.
+func (s *server) handleEnv(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	switch kind {
+	case "", kindEnv, kindFile, kindFileJSON:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown kind %q (env, file or file_json)", kind))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"started":   s.probe.started,
+		"variables": s.probe.snapshot(kind),
+	})
+}
+
+// handleEvents lists the transitions seen since startup, or only those after
+// the given sequence number so a poller can pick up where it left off.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	since := 0
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be a sequence number")
+			return
+		}
+		since = n
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"events": s.probe.eventsSince(since),
+	})
+}
+
+// handleWait blocks until var matches (an exact value, or just set/unset) and
+// answers 200, or answers 408 with the last state when the timeout runs out.
+// A variable that already matches answers at once, which is the usual case
+// for a pod the operator restarted with the patch in place.
+func (s *server) handleWait(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("var")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "var is required")
+		return
+	}
+	match, err := waitMatcher(q.Get("state"), q.Get("value"), q.Has("value"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	timeout := 60 * time.Second
+	if raw := q.Get("timeout"); raw != "" {
+		if timeout, err = time.ParseDuration(raw); err != nil {
+			writeError(w, http.StatusBadRequest, "timeout must be a duration such as 90s")
+			return
+		}
+	}
+	if timeout > maxWait {
+		timeout = maxWait
+	}
+
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		st, changed, ok := s.probe.state(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("%s is not in WATCH_ENV or WATCH_FILES", name))
+			return
+		}
+		if match(st.Value) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"matched":   true,
+				"state":     st,
+				"waited_ms": time.Since(start).Milliseconds(),
+			})
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			writeJSON(w, http.StatusRequestTimeout, map[string]interface{}{
+				"matched":   false,
+				"state":     st,
+				"waited_ms": time.Since(start).Milliseconds(),
+			})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// waitMatcher builds the /wait condition. value wins over state; with
+// neither, the variable only has to be set.
+func waitMatcher(state, value string, hasValue bool) (func(*string) bool, error) {
+	if hasValue {
+		return func(v *string) bool { return v != nil && *v == value }, nil
+	}
+	switch state {
+	case "", "set":
+		return func(v *string) bool { return v != nil }, nil
+	case "unset":
+		return func(v *string) bool { return v == nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown state %q (set or unset)", state)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
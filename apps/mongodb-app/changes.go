@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CHANGE_STREAM=1 watches the database the app is connected to (the branch)
+// and CHANGE_STREAM_SOURCE=1 also watches SOURCE_DATABASE_URL. Events are
+// logged and kept for GET /changes. Every document the HTTP API inserts is
+// remembered, so a source event for one of them is flagged as from_this_app:
+// that is a branch write leaking into the source. Updates and deletes are not
+// remembered: they hit documents copied from the source, whose _id the source
+// shares, so the source's own changes to them would be flagged too. Change streams need a
+// replica set; on a standalone server the watcher reports "unsupported".
+
+const changeHistory = 100
+
+// codeChangeStreamNotSupported is returned by a standalone mongod for $changeStream.
+const codeChangeStreamNotSupported = 40573
+
+type changeEvent struct {
+	Operation   string    `json:"operation"`
+	Collection  string    `json:"collection"`
+	DocumentKey string    `json:"document_key,omitempty"`
+	FromThisApp bool      `json:"from_this_app"`
+	At          time.Time `json:"at"`
+}
+
+// writeLog holds the _id of every document inserted through the API and
+// committed on the branch.
+type writeLog struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func newWriteLog() *writeLog {
+	return &writeLog{ids: map[string]bool{}}
+}
+
+func (w *writeLog) add(id interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ids[fmt.Sprint(id)] = true
+}
+
+func (w *writeLog) has(id interface{}) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ids[fmt.Sprint(id)]
+}
+
+func (w *writeLog) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.ids)
+}
+
+type changeWatcher struct {
+	name   string
+	db     *mongo.Database
+	writes *writeLog
+
+	mu          sync.Mutex
+	state       string
+	err         string
+	restarts    int
+	total       int
+	fromThisApp int
+	events      []changeEvent
+	resumeToken bson.Raw
+}
+
+func startChangeWatcher(ctx context.Context, name string, db *mongo.Database, writes *writeLog) *changeWatcher {
+	w := &changeWatcher{name: name, db: db, writes: writes, state: "starting"}
+	go w.run(ctx)
+	return w
+}
+
+func (w *changeWatcher) run(ctx context.Context) {
+	for {
+		err := w.watch(ctx)
+		if ctx.Err() != nil {
+			w.setState("stopped", "")
+			return
+		}
+		var se mongo.ServerError
+		if errors.As(err, &se) && se.HasErrorCode(codeChangeStreamNotSupported) {
+			log.Printf("Change stream on %s not supported (not a replica set): %v", w.name, err)
+			w.setState("unsupported", err.Error())
+			return
+		}
+		log.Printf("Change stream on %s failed, retrying in 5s: %v", w.name, err)
+		w.setState("failed", err.Error())
+		w.mu.Lock()
+		w.restarts++
+		w.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			w.setState("stopped", "")
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// watch opens the stream, resuming after the last event seen, and reads it
+// until it fails.
+func (w *changeWatcher) watch(ctx context.Context) error {
+	opts := options.ChangeStream()
+	w.mu.Lock()
+	if w.resumeToken != nil {
+		opts.SetResumeAfter(w.resumeToken)
+	}
+	w.mu.Unlock()
+
+	stream, err := w.db.Watch(ctx, mongo.Pipeline{}, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+	log.Printf("Watching change stream on %s (%s)", w.name, w.db.Name())
+	w.setState("watching", "")
+
+	for stream.Next(ctx) {
+		var ev struct {
+			OperationType string `bson:"operationType"`
+			NS            struct {
+				Coll string `bson:"coll"`
+			} `bson:"ns"`
+			DocumentKey bson.Raw `bson:"documentKey"`
+		}
+		if err := stream.Decode(&ev); err != nil {
+			return fmt.Errorf("decode change event: %w", err)
+		}
+		w.record(ev.OperationType, ev.NS.Coll, ev.DocumentKey, stream.ResumeToken())
+	}
+	return stream.Err()
+}
+
+func (w *changeWatcher) record(op, coll string, key bson.Raw, token bson.Raw) {
+	e := changeEvent{Operation: op, Collection: coll, At: time.Now()}
+	if key != nil {
+		if id, err := key.LookupErr("_id"); err == nil {
+			var v interface{}
+			if id.Unmarshal(&v) == nil {
+				e.FromThisApp = w.writes.has(v)
+			}
+		}
+		if js, err := bson.MarshalExtJSON(key, false, false); err == nil {
+			e.DocumentKey = string(js)
+		}
+	}
+	log.Printf("[%s change] %s %s %s", w.name, e.Operation, e.Collection, e.DocumentKey)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.resumeToken = token
+	w.total++
+	if e.FromThisApp {
+		w.fromThisApp++
+	}
+	w.events = append(w.events, e)
+	if len(w.events) > changeHistory {
+		w.events = w.events[len(w.events)-changeHistory:]
+	}
+}
+
+func (w *changeWatcher) setState(state, err string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state, w.err = state, err
+}
+
+func (w *changeWatcher) status() map[string]interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return map[string]interface{}{
+		"database":      w.db.Name(),
+		"state":         w.state,
+		"error":         w.err,
+		"restarts":      w.restarts,
+		"total":         w.total,
+		"from_this_app": w.fromThisApp,
+		"events":        append([]changeEvent{}, w.events...),
+	}
+}
+
+func (w *changeWatcher) leaked() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fromThisApp
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VERIFY_COPY_SPEC=<file> checks a filtered branch copy collection by
+// collection. The file lists the filter each collection was copied with, in
+// the same shape as the db_branches copy section:
+//
+//	{"collections": {"users": {"filter": "{\"age\": {\"$gte\": 24}}"}}}
+//
+// A mirrord config can be passed as is; the copy section of its first
+// db_branches entry is used. For every listed collection the check requires
+// that each branch document matches the filter, that each source document
+// matching it is on the branch, and that copied documents are unchanged.
+// Documents are keyed by _id. A JSON report is printed and the process exits
+// 1 on any difference.
+
+const copySampleLimit = 10
+
+type collectionSpec struct {
+	Filter string `json:"filter"`
+}
+
+type copySpec struct {
+	Collections map[string]collectionSpec `json:"collections"`
+}
+
+type copyCollectionResult struct {
+	Collection     string   `json:"collection"`
+	Filter         string   `json:"filter"`
+	SourceMatching int      `json:"source_matching"`
+	BranchDocs     int      `json:"branch_docs"`
+	Missing        int      `json:"missing"`
+	NotMatching    int      `json:"not_matching"`
+	Changed        int      `json:"changed"`
+	Extra          int      `json:"extra"`
+	Samples        []string `json:"samples,omitempty"`
+	Error          string   `json:"error,omitempty"`
+	Passed         bool     `json:"passed"`
+}
+
+func (r *copyCollectionResult) sample(format string, args ...any) {
+	if len(r.Samples) < copySampleLimit {
+		r.Samples = append(r.Samples, fmt.Sprintf(format, args...))
+	}
+}
+
+type copySpecReport struct {
+	Engine      string                 `json:"engine"`
+	Spec        string                 `json:"spec"`
+	Passed      bool                   `json:"passed"`
+	Branch      map[string]string      `json:"branch"`
+	Source      map[string]string      `json:"source"`
+	Collections []copyCollectionResult `json:"collections"`
+	Error       string                 `json:"error,omitempty"`
+	DurationMs  int64                  `json:"duration_ms"`
+}
+
+func loadCopySpec(path string) (*copySpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		copySpec
+		Feature struct {
+			DBBranches []struct {
+				Copy copySpec `json:"copy"`
+			} `json:"db_branches"`
+		} `json:"feature"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	spec := file.copySpec
+	if len(spec.Collections) == 0 && len(file.Feature.DBBranches) > 0 {
+		spec = file.Feature.DBBranches[0].Copy
+	}
+	if len(spec.Collections) == 0 {
+		return nil, fmt.Errorf("%s lists no collections", path)
+	}
+	return &spec, nil
+}
+
+func runCopySpecCheck(client *mongo.Client, dbName, path string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	report := &copySpecReport{Engine: "mongodb", Spec: path, Collections: []copyCollectionResult{}}
+
+	spec, err := loadCopySpec(path)
+	if err != nil {
+		report.Error = err.Error()
+		return finishCopySpecReport(report, start)
+	}
+	sourceClient, sourceDBName, err := openSource(ctx, dbName)
+	if err != nil {
+		report.Error = "source: " + err.Error()
+		return finishCopySpecReport(report, start)
+	}
+	defer sourceClient.Disconnect(context.Background())
+	branch := client.Database(dbName)
+	source := sourceClient.Database(sourceDBName)
+	report.Branch = mongoIdentity(ctx, branch, os.Getenv("DATABASE_URL"))
+	report.Source = mongoIdentity(ctx, source, os.Getenv("SOURCE_DATABASE_URL"))
+
+	names := make([]string, 0, len(spec.Collections))
+	for name := range spec.Collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.Collections = append(report.Collections,
+			checkCopiedCollection(ctx, source.Collection(name), branch.Collection(name), spec.Collections[name].Filter))
+	}
+	return finishCopySpecReport(report, start)
+}
+
+func checkCopiedCollection(ctx context.Context, source, branch *mongo.Collection, filterJSON string) copyCollectionResult {
+	result := copyCollectionResult{Collection: source.Name(), Filter: filterJSON}
+	fail := func(err error) copyCollectionResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	filter := bson.M{}
+	if strings.TrimSpace(filterJSON) != "" {
+		if err := bson.UnmarshalExtJSON([]byte(filterJSON), false, &filter); err != nil {
+			return fail(fmt.Errorf("parse filter: %w", err))
+		}
+	}
+
+	sourceDocs, err := keyedDocs(ctx, source, filter)
+	if err != nil {
+		return fail(fmt.Errorf("source: %w", err))
+	}
+	branchDocs, err := keyedDocs(ctx, branch, bson.M{})
+	if err != nil {
+		return fail(fmt.Errorf("branch: %w", err))
+	}
+	// The filter is evaluated by the branch server as well, so query
+	// semantics (type brackets, missing fields) match what the copy used.
+	branchMatching, err := matchingIDs(ctx, branch, filter)
+	if err != nil {
+		return fail(fmt.Errorf("branch filter: %w", err))
+	}
+
+	result.SourceMatching = len(sourceDocs)
+	result.BranchDocs = len(branchDocs)
+	for id, doc := range branchDocs {
+		srcDoc, fromSource := sourceDocs[id]
+		switch {
+		case !branchMatching[id]:
+			result.NotMatching++
+			result.sample("not matching filter: %s", doc)
+		case !fromSource:
+			result.Extra++
+			result.sample("not a matching source document: %s", doc)
+		case srcDoc != doc:
+			result.Changed++
+			result.sample("changed: branch %s source %s", doc, srcDoc)
+		}
+	}
+	for id, doc := range sourceDocs {
+		if _, ok := branchDocs[id]; !ok {
+			result.Missing++
+			result.sample("missing: %s", doc)
+		}
+	}
+	sort.Strings(result.Samples)
+	result.Passed = result.Missing+result.NotMatching+result.Changed+result.Extra == 0
+	return result
+}
+
+// keyedDocs returns the documents matching filter as canonical extended JSON,
+// keyed by the extended JSON of their _id.
+func keyedDocs(ctx context.Context, coll *mongo.Collection, filter bson.M) (map[string]string, error) {
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	docs := map[string]string{}
+	for cursor.Next(ctx) {
+		text, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		if err != nil {
+			return nil, err
+		}
+		docs[cursor.Current.Lookup("_id").String()] = string(text)
+	}
+	return docs, cursor.Err()
+}
+
+func matchingIDs(ctx context.Context, coll *mongo.Collection, filter bson.M) (map[string]bool, error) {
+	cursor, err := coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := map[string]bool{}
+	for cursor.Next(ctx) {
+		ids[cursor.Current.Lookup("_id").String()] = true
+	}
+	return ids, cursor.Err()
+}
+
+func finishCopySpecReport(report *copySpecReport, start time.Time) int {
+	report.Passed = report.Error == ""
+	for _, c := range report.Collections {
+		if !c.Passed {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Copy spec check FAILED")
+		return 1
+	}
+	log.Println("Copy spec check passed")
+	return 0
+}
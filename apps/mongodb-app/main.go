@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,27 +12,26 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type User struct {
-	ID    int    `bson:"id"`
-	Name  string `bson:"name"`
-	Email string `bson:"email"`
-	Age   int    `bson:"age"`
+	ID    int    `bson:"id" json:"id"`
+	Name  string `bson:"name" json:"name"`
+	Email string `bson:"email" json:"email"`
+	Age   int    `bson:"age" json:"age"`
 }
 
 type Order struct {
-	ID     int     `bson:"id"`
-	UserID int     `bson:"user_id"`
-	Amount float64 `bson:"amount"`
-	Status string  `bson:"status"`
+	ID     int     `bson:"id" json:"id"`
+	UserID int     `bson:"user_id" json:"user_id"`
+	Amount float64 `bson:"amount" json:"amount"`
+	Status string  `bson:"status" json:"status"`
 }
 
 type Product struct {
-	ID    int     `bson:"id"`
-	Name  string  `bson:"name"`
-	Price float64 `bson:"price"`
+	ID    int     `bson:"id" json:"id"`
+	Name  string  `bson:"name" json:"name"`
+	Price float64 `bson:"price" json:"price"`
 }
 
 func main() {
@@ -42,18 +43,24 @@ func main() {
 	}
 
 	log.Printf("Connecting to database: %s", maskPassword(dbURL))
+	if err := installSRVStandIn(); err != nil {
+		log.Fatalf("Invalid SRV stand-in: %v", err)
+	}
+	clientOpts, cs, err := clientOptions(dbURL)
+	if err != nil {
+		log.Fatalf("Invalid DATABASE_URL: %v", err)
+	}
+	log.Printf("Connection string: %s", describeURI(cs))
 
 	// Connect to MongoDB with retries
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	clientOptions := options.Client().ApplyURI(dbURL)
 	var client *mongo.Client
-	var err error
 
 	maxRetries := 10
 	for i := 0; i < maxRetries; i++ {
-		client, err = mongo.Connect(ctx, clientOptions)
+		client, err = mongo.Connect(ctx, clientOpts)
 		if err == nil {
 			err = client.Ping(ctx, nil)
 			if err == nil {
@@ -72,13 +79,21 @@ func main() {
 
 	log.Println("Connected to MongoDB database")
 
-	// Get database name from URL or use default
-	dbName := os.Getenv("MONGODB_DATABASE")
-	if dbName == "" {
-		dbName = "source_db"
-	}
+	dbName := databaseName(cs, os.Getenv("MONGODB_DATABASE"))
+	log.Printf("Using database: %s", dbName)
 	db := client.Database(dbName)
 
+	if isVerifyMode() {
+		code := runIsolationCheck(client, dbName)
+		client.Disconnect(context.Background())
+		os.Exit(code)
+	}
+	if spec := os.Getenv("VERIFY_COPY_SPEC"); spec != "" {
+		code := runCopySpecCheck(client, dbName, spec)
+		client.Disconnect(context.Background())
+		os.Exit(code)
+	}
+
 	// List collections
 	collections, err := db.ListCollectionNames(ctx, bson.M{})
 	if err != nil {
@@ -95,7 +110,7 @@ func main() {
 	} else {
 		var users []User
 		if err := cursor.All(ctx, &users); err != nil {
-			log.Printf("Warning: Failed to decode users: %v", err)
+			log.Fatalf("Failed to decode users: %v", err)
 		} else {
 			log.Printf("Found %d users:", len(users))
 			for _, u := range users {
@@ -112,7 +127,7 @@ func main() {
 	} else {
 		var orders []Order
 		if err := orderCursor.All(ctx, &orders); err != nil {
-			log.Printf("Warning: Failed to decode orders: %v", err)
+			log.Fatalf("Failed to decode orders: %v", err)
 		} else {
 			log.Printf("Found %d orders:", len(orders))
 			for _, o := range orders {
@@ -129,7 +144,7 @@ func main() {
 	} else {
 		var products []Product
 		if err := productCursor.All(ctx, &products); err != nil {
-			log.Printf("Warning: Failed to decode products: %v", err)
+			log.Fatalf("Failed to decode products: %v", err)
 		} else {
 			log.Printf("Found %d products:", len(products))
 			for _, p := range products {
@@ -138,6 +153,34 @@ func main() {
 		}
 	}
 
+	// Optional change stream watchers on the branch and on the source.
+	writes := newWriteLog()
+	watchCtx, stopWatchers := context.WithCancel(context.Background())
+	defer stopWatchers()
+	var changes, sourceChanges *changeWatcher
+	if isEnabled("CHANGE_STREAM") {
+		changes = startChangeWatcher(watchCtx, "branch", db, writes)
+	}
+	if isEnabled("CHANGE_STREAM_SOURCE") {
+		sourceClient, sourceDBName, err := openSource(ctx, dbName)
+		if err != nil {
+			log.Fatalf("Failed to connect to source for its change stream: %v", err)
+		}
+		defer sourceClient.Disconnect(context.Background())
+		sourceChanges = startChangeWatcher(watchCtx, "source", sourceClient.Database(sourceDBName), writes)
+	}
+
+	// Serve the API so tests can write through the app while a branch is
+	// active, instead of relying on the startup output above.
+	port := getEnvDefault("PORT", "8080")
+	srv := &http.Server{Addr: ":" + port, Handler: newServer(db, dbURL, writes, changes, sourceChanges)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+	log.Printf("HTTP API listening on :%s", port)
+
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -147,35 +190,22 @@ func main() {
 	// Keep running
 	<-sigChan
 	log.Println("Shutting down...")
-}
 
-func maskPassword(uri string) string {
-	// Simple password masking for logs
-	masked := ""
-	inPassword := false
-	colonCount := 0
-
-	for _, c := range uri {
-		if c == ':' {
-			colonCount++
-			if colonCount == 3 {
-				// After mongodb://user: comes the password
-				inPassword = true
-				masked += ":"
-				continue
-			}
-		}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+}
 
-		if inPassword && c == '@' {
-			masked += "****@"
-			inPassword = false
-			continue
-		}
+func isEnabled(name string) bool {
+	v := os.Getenv(name)
+	return v == "1" || v == "true"
+}
 
-		if !inPassword {
-			masked += string(c)
-		}
+func getEnvDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
 	}
-
-	return masked
+	return def
 }
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// codeIllegalOperation is what a standalone mongod returns for a transaction.
+const codeIllegalOperation = 20
+
+// errAbortRequested rolls a transaction back on purpose (POST /orders with
+// "abort": true), so a test can confirm nothing from it is visible afterwards.
+var errAbortRequested = errors.New("abort requested")
+
+type userDoc struct {
+	OID  interface{} `bson:"_id,omitempty" json:"-"`
+	User `bson:",inline"`
+}
+
+type userRequest struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+	Age   *int    `json:"age"`
+}
+
+type orderRequest struct {
+	UserID int     `json:"user_id"`
+	Amount float64 `json:"amount"`
+	Status string  `json:"status"`
+	Abort  bool    `json:"abort"`
+}
+
+// server exposes users and orders over HTTP so a test can write through the
+// app while a branch is active and then check the source with query:source.
+type server struct {
+	client  *mongo.Client
+	db      *mongo.Database
+	uri     string
+	writes  *writeLog
+	changes *changeWatcher
+	source  *changeWatcher
+}
+
+// newServer takes the optional change stream watchers started from
+// CHANGE_STREAM and CHANGE_STREAM_SOURCE; either may be nil.
+func newServer(db *mongo.Database, uri string, writes *writeLog, changes, source *changeWatcher) http.Handler {
+	s := &server{client: db.Client(), db: db, uri: uri, writes: writes, changes: changes, source: source}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/whoami", s.handleWhoami)
+	mux.HandleFunc("/users", s.handleUsers)
+	mux.HandleFunc("/users/", s.handleUser)
+	mux.HandleFunc("/orders", s.handleOrders)
+	mux.HandleFunc("/indexes", s.handleIndexes)
+	mux.HandleFunc("/changes", s.handleChanges)
+	return logRequests(mux)
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"service": "mongodb-app",
+		"endpoints": []string{
+			"GET /health",
+			"GET /whoami",
+			"GET /users",
+			"POST /users {\"name\":\"...\",\"email\":\"...\",\"age\":30}",
+			"GET /users/{id}",
+			"PUT /users/{id} {\"name\":\"...\"}",
+			"DELETE /users/{id}",
+			"GET /orders",
+			"POST /orders {\"user_id\":1,\"amount\":10,\"abort\":false} (transaction)",
+			"GET /indexes?collection=users",
+			"GET /changes",
+		},
+	})
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := s.client.Ping(r.Context(), nil); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleWhoami reports which server the app is really talking to and
+// whether it is a replica set member, which transactions and change streams
+// both need.
+func (s *server) handleWhoami(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	var hello struct {
+		SetName           string `bson:"setName"`
+		IsWritablePrimary bool   `bson:"isWritablePrimary"`
+		Msg               string `bson:"msg"`
+		MaxWireVersion    int32  `bson:"maxWireVersion"`
+	}
+	err := s.client.Database("admin").RunCommand(r.Context(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{}
+	for k, v := range mongoIdentity(r.Context(), s.db, s.uri) {
+		resp[k] = v
+	}
+	resp["replica_set"] = hello.SetName
+	resp["writable_primary"] = hello.IsWritablePrimary
+	resp["max_wire_version"] = hello.MaxWireVersion
+	resp["transactions"] = hello.SetName != "" || hello.Msg == "isdbgrid"
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listUsers(w, r)
+	case http.MethodPost:
+		s.insertUser(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *server) handleUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/users/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "user id must be an integer")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getUser(w, r, id)
+	case http.MethodPut, http.MethodPatch:
+		s.updateUser(w, r, id)
+	case http.MethodDelete:
+		s.deleteUser(w, r, id)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+func (s *server) listUsers(w http.ResponseWriter, r *http.Request) {
+	cursor, err := s.db.Collection("users").Find(r.Context(), bson.M{}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	users := []User{}
+	if err := cursor.All(r.Context(), &users); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(users), "users": users})
+}
+
+func (s *server) getUser(w http.ResponseWriter, r *http.Request, id int) {
+	var u userDoc
+	err := s.db.Collection("users").FindOne(r.Context(), bson.M{"id": id}).Decode(&u)
+	writeDoc(w, http.StatusOK, u.User, "user", id, err)
+}
+
+// insertUser takes the next integer id; users written by two instances at
+// once may collide on it, which is fine for a test app.
+func (s *server) insertUser(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeUser(w, r, true)
+	if !ok {
+		return
+	}
+	id, err := nextID(r.Context(), s.db.Collection("users"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	u := User{ID: id, Name: *req.Name, Email: *req.Email}
+	if req.Age != nil {
+		u.Age = *req.Age
+	}
+	res, err := s.db.Collection("users").InsertOne(r.Context(), u)
+	if err != nil {
+		writeWriteError(w, err)
+		return
+	}
+	s.writes.add(res.InsertedID)
+	log.Printf("Inserted user: ID %d, Name %s (_id %v)", u.ID, u.Name, res.InsertedID)
+	writeJSON(w, http.StatusCreated, u)
+}
+
+func (s *server) updateUser(w http.ResponseWriter, r *http.Request, id int) {
+	req, ok := decodeUser(w, r, false)
+	if !ok {
+		return
+	}
+	set := bson.M{}
+	if req.Name != nil {
+		set["name"] = *req.Name
+	}
+	if req.Email != nil {
+		set["email"] = *req.Email
+	}
+	if req.Age != nil {
+		set["age"] = *req.Age
+	}
+	if len(set) == 0 {
+		writeError(w, http.StatusBadRequest, "nothing to update")
+		return
+	}
+
+	var u userDoc
+	err := s.db.Collection("users").FindOneAndUpdate(r.Context(), bson.M{"id": id}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&u)
+	if err == nil {
+		log.Printf("Updated user: ID %d, Name %s", u.ID, u.Name)
+	} else if mongo.IsDuplicateKeyError(err) {
+		writeWriteError(w, err)
+		return
+	}
+	writeDoc(w, http.StatusOK, u.User, "user", id, err)
+}
+
+func (s *server) deleteUser(w http.ResponseWriter, r *http.Request, id int) {
+	var u userDoc
+	err := s.db.Collection("users").FindOneAndDelete(r.Context(), bson.M{"id": id}).Decode(&u)
+	if err == nil {
+		log.Printf("Deleted user: ID %d, Name %s", u.ID, u.Name)
+	}
+	writeDoc(w, http.StatusOK, u.User, "user", id, err)
+}
+
+func (s *server) handleOrders(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cursor, err := s.db.Collection("orders").Find(r.Context(), bson.M{}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		orders := []Order{}
+		if err := cursor.All(r.Context(), &orders); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(orders), "orders": orders})
+	case http.MethodPost:
+		s.placeOrder(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// placeOrder inserts an order and bumps the user's order_count in one
+// multi-document transaction. With "abort": true the transaction is rolled
+// back after both writes and the response says whether the order is really
+// gone. Standalone servers reject transactions; that comes back as 409.
+func (s *server) placeOrder(w http.ResponseWriter, r *http.Request) {
+	var req orderRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, "amount must be positive")
+		return
+	}
+	if req.Status == "" {
+		req.Status = "pending"
+	}
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer session.EndSession(context.Background())
+
+	var order Order
+	var orderOID interface{}
+	users, orders := s.db.Collection("users"), s.db.Collection("orders")
+	_, err = session.WithTransaction(r.Context(), func(sc mongo.SessionContext) (interface{}, error) {
+		if err := users.FindOneAndUpdate(sc, bson.M{"id": req.UserID}, bson.M{"$inc": bson.M{"order_count": 1}}).Err(); err != nil {
+			return nil, err
+		}
+		id, err := nextID(sc, orders)
+		if err != nil {
+			return nil, err
+		}
+		order = Order{ID: id, UserID: req.UserID, Amount: req.Amount, Status: req.Status}
+		res, err := orders.InsertOne(sc, order)
+		if err != nil {
+			return nil, err
+		}
+		orderOID = res.InsertedID
+		if req.Abort {
+			return nil, errAbortRequested
+		}
+		return nil, nil
+	})
+
+	var se mongo.ServerError
+	switch {
+	case errors.Is(err, errAbortRequested):
+		visible, cerr := orders.CountDocuments(r.Context(), bson.M{"_id": orderOID})
+		if cerr != nil {
+			writeError(w, http.StatusInternalServerError, cerr.Error())
+			return
+		}
+		log.Printf("Aborted order transaction: order %d visible afterwards: %d", order.ID, visible)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "aborted", "order": order, "rolled_back": visible == 0,
+		})
+	case errors.Is(err, mongo.ErrNoDocuments):
+		writeError(w, http.StatusNotFound, fmt.Sprintf("user %d not found", req.UserID))
+	case errors.As(err, &se) && se.HasErrorCode(codeIllegalOperation):
+		writeError(w, http.StatusConflict, "transactions need a replica set: "+err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		s.writes.add(orderOID)
+		log.Printf("Committed order transaction: order %d for user %d", order.ID, order.UserID)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"status": "committed", "order": order})
+	}
+}
+
+// handleIndexes lists the indexes of one collection (?collection=) or of all
+// of them, so a branch can be compared with the source's.
+func (s *server) handleIndexes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	names := []string{r.URL.Query().Get("collection")}
+	if names[0] == "" {
+		var err error
+		names, err = s.db.ListCollectionNames(r.Context(), bson.M{"type": "collection"})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	result := map[string][]json.RawMessage{}
+	for _, name := range names {
+		cursor, err := s.db.Collection(name).Indexes().List(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		specs := []json.RawMessage{}
+		for cursor.Next(r.Context()) {
+			js, err := bson.MarshalExtJSON(cursor.Current, false, false)
+			if err != nil {
+				cursor.Close(r.Context())
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			specs = append(specs, js)
+		}
+		if err := cursor.Err(); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		cursor.Close(r.Context())
+		result[name] = specs
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if s.changes == nil && s.source == nil {
+		writeError(w, http.StatusNotFound, "change streams are off, set CHANGE_STREAM and/or CHANGE_STREAM_SOURCE")
+		return
+	}
+	resp := map[string]interface{}{"app_writes": s.writes.len()}
+	if s.changes != nil {
+		resp["branch"] = s.changes.status()
+	}
+	if s.source != nil {
+		resp["source"] = s.source.status()
+		resp["leaked"] = s.source.leaked() > 0
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// nextID returns one more than the highest integer id in the collection.
+func nextID(ctx context.Context, coll *mongo.Collection) (int, error) {
+	var last struct {
+		ID int `bson:"id"`
+	}
+	err := coll.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "id", Value: -1}})).Decode(&last)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return last.ID + 1, nil
+}
+
+func decodeUser(w http.ResponseWriter, r *http.Request, create bool) (userRequest, bool) {
+	var req userRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return req, false
+	}
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{{"name", req.Name}, {"email", req.Email}} {
+		if f.value == nil {
+			if create {
+				writeError(w, http.StatusBadRequest, f.name+" is required")
+				return req, false
+			}
+			continue
+		}
+		*f.value = strings.TrimSpace(*f.value)
+		if *f.value == "" || len(*f.value) > 255 {
+			writeError(w, http.StatusBadRequest, f.name+" must be 1 to 255 characters")
+			return req, false
+		}
+	}
+	if req.Age != nil && *req.Age < 0 {
+		writeError(w, http.StatusBadRequest, "age must not be negative")
+		return req, false
+	}
+	return req, true
+}
+
+func writeDoc(w http.ResponseWriter, status int, v interface{}, kind string, id int, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %d not found", kind, id))
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, status, v)
+	}
+}
+
+// writeWriteError maps the unique email index to 409.
+func writeWriteError(w http.ResponseWriter, err error) {
+	if mongo.IsDuplicateKeyError(err) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s (%s)", r.Method, r.URL.Path, time.Since(start).Round(time.Millisecond))
+	})
+}
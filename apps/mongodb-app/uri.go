@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/dns"
+)
+
+// DATABASE_URL and SOURCE_DATABASE_URL are parsed with the driver's connstring
+// package, so whatever the operator hands a branch works: several hosts, no
+// port, mongodb+srv, and authSource/authMechanism in the query. The database
+// is taken from the URI path; MONGODB_DATABASE (SOURCE_MONGODB_DATABASE for the
+// source) overrides it and source_db is the fallback when neither is set.
+//
+// MONGODB_AUTH_SOURCE and MONGODB_AUTH_MECHANISM override the URI's options,
+// for branches whose URI carries the user but not where it is defined.
+//
+// mongodb+srv needs SRV records. Inside the cluster a headless Service with a
+// port named "mongodb" provides them. Outside it, MONGODB_SRV_HOSTS=h1:27017,...
+// stands in for the SRV lookup and MONGODB_SRV_TXT for the TXT one
+// ("authSource=admin"). The hosts must share the SRV name's parent domain,
+// as the driver checks that.
+
+const defaultDatabase = "source_db"
+
+// clientOptions parses uri and returns the options to connect with.
+func clientOptions(uri string) (*options.ClientOptions, *connstring.ConnString, error) {
+	cs, err := connstring.ParseAndValidate(uri)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse MongoDB URI: %w", err)
+	}
+	opts := options.Client().ApplyURI(uri)
+
+	authSource, mechanism := os.Getenv("MONGODB_AUTH_SOURCE"), os.Getenv("MONGODB_AUTH_MECHANISM")
+	if authSource != "" || mechanism != "" {
+		cred := options.Credential{
+			AuthMechanism:           cs.AuthMechanism,
+			AuthMechanismProperties: cs.AuthMechanismProperties,
+			AuthSource:              cs.AuthSource,
+			Username:                cs.Username,
+			Password:                cs.Password,
+			PasswordSet:             cs.PasswordSet,
+		}
+		if authSource != "" {
+			cred.AuthSource, cs.AuthSource = authSource, authSource
+		}
+		if mechanism != "" {
+			cred.AuthMechanism, cs.AuthMechanism = mechanism, mechanism
+		}
+		opts.SetAuth(cred)
+	}
+	return opts, cs, nil
+}
+
+// databaseName picks the database: override, then the URI path, then source_db.
+func databaseName(cs *connstring.ConnString, override string) string {
+	if override != "" {
+		return override
+	}
+	if cs.Database != "" {
+		return cs.Database
+	}
+	return defaultDatabase
+}
+
+// describeURI summarizes a parsed URI for logs, without the password.
+func describeURI(cs *connstring.ConnString) string {
+	parts := []string{
+		"scheme=" + cs.Scheme,
+		"hosts=" + strings.Join(cs.Hosts, ","),
+		"database=" + cs.Database,
+	}
+	if cs.Username != "" {
+		parts = append(parts, "user="+cs.Username, "authSource="+cs.AuthSource)
+	}
+	if cs.AuthMechanism != "" {
+		parts = append(parts, "authMechanism="+cs.AuthMechanism)
+	}
+	if cs.ReplicaSet != "" {
+		parts = append(parts, "replicaSet="+cs.ReplicaSet)
+	}
+	if cs.DirectConnectionSet {
+		parts = append(parts, "directConnection="+strconv.FormatBool(cs.DirectConnection))
+	}
+	if cs.SSLSet {
+		parts = append(parts, "tls="+strconv.FormatBool(cs.SSL))
+	}
+	return strings.Join(parts, " ")
+}
+
+// installSRVStandIn replaces the driver's SRV and TXT lookups when
+// MONGODB_SRV_HOSTS is set. Every SRV name resolves to those hosts.
+func installSRVStandIn() error {
+	hosts := os.Getenv("MONGODB_SRV_HOSTS")
+	if hosts == "" {
+		return nil
+	}
+	var records []*net.SRV
+	for _, h := range strings.Split(hosts, ",") {
+		host, portStr, err := net.SplitHostPort(strings.TrimSpace(h))
+		if err != nil {
+			return fmt.Errorf("MONGODB_SRV_HOSTS entry %q: %w", h, err)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return fmt.Errorf("MONGODB_SRV_HOSTS entry %q: %w", h, err)
+		}
+		records = append(records, &net.SRV{Target: host + ".", Port: uint16(port)})
+	}
+	txt := os.Getenv("MONGODB_SRV_TXT")
+
+	dns.DefaultResolver.LookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		log.Printf("SRV lookup _%s._%s.%s answered from MONGODB_SRV_HOSTS", service, proto, name)
+		return "", records, nil
+	}
+	dns.DefaultResolver.LookupTXT = func(name string) ([]string, error) {
+		if txt == "" {
+			return nil, nil
+		}
+		return []string{txt}, nil
+	}
+	return nil
+}
+
+// maskPassword replaces the password in a URI for logs. It works on the
+// string so it never triggers an SRV lookup; the credentials end at the last
+// @ before the path, and the spec requires @ and / in them to be escaped.
+func maskPassword(uri string) string {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return uri
+	}
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		end = len(rest)
+	}
+	at := strings.LastIndex(rest[:end], "@")
+	if at < 0 {
+		return uri
+	}
+	user, _, hasPassword := strings.Cut(rest[:at], ":")
+	if !hasPassword {
+		return uri
+	}
+	return scheme + "://" + user + ":****" + rest[at:]
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VERIFY_ISOLATION=1 turns the app into a one-shot branch check: it writes a
+// tagged document to the branch (DATABASE_URL), confirms it is not visible in
+// the source (SOURCE_DATABASE_URL), compares per-collection document counts
+// and prints a JSON report. The process exits 0 when every check passed and 1
+// otherwise.
+//
+// VERIFY_COUNTS decides how document counts are judged:
+//
+//	report  record counts only (default)
+//	match   every source collection has the same count on the branch
+//	empty   every source collection is missing or empty on the branch
+//
+// VERIFY_EXPECT_COUNTS=users=3,orders=4 pins exact branch counts for filtered
+// copies and takes precedence over VERIFY_COUNTS for the collections it names.
+// The source database is taken from SOURCE_DATABASE_URL's path, falls back to
+// the branch database and can be overridden with SOURCE_MONGODB_DATABASE.
+
+type check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type collectionCount struct {
+	Collection string `json:"collection"`
+	Source     *int64 `json:"source"`
+	Branch     *int64 `json:"branch"`
+	Expected   *int64 `json:"expected,omitempty"`
+	Passed     bool   `json:"passed"`
+}
+
+type isolationReport struct {
+	Engine      string            `json:"engine"`
+	Passed      bool              `json:"passed"`
+	Tag         string            `json:"tag"`
+	CountMode   string            `json:"count_mode"`
+	Branch      map[string]string `json:"branch"`
+	Source      map[string]string `json:"source"`
+	Checks      []check           `json:"checks"`
+	Collections []collectionCount `json:"collections"`
+	DurationMs  int64             `json:"duration_ms"`
+}
+
+func (r *isolationReport) add(name string, passed bool, detail string) {
+	r.Checks = append(r.Checks, check{Name: name, Passed: passed, Detail: detail})
+}
+
+func isVerifyMode() bool {
+	v := os.Getenv("VERIFY_ISOLATION")
+	return v == "1" || v == "true"
+}
+
+// runIsolationCheck runs the checks against an already connected branch and
+// returns the exit code.
+func runIsolationCheck(client *mongo.Client, dbName string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	report := &isolationReport{
+		Engine:      "mongodb",
+		Tag:         fmt.Sprintf("isolation-check-%d", time.Now().UnixNano()),
+		CountMode:   os.Getenv("VERIFY_COUNTS"),
+		Collections: []collectionCount{},
+	}
+	if report.CountMode == "" {
+		report.CountMode = "report"
+	}
+
+	sourceClient, sourceDBName, err := openSource(ctx, dbName)
+	if err != nil {
+		report.add("source_connect", false, err.Error())
+		return finishReport(report, start)
+	}
+	defer sourceClient.Disconnect(context.Background())
+	report.add("source_connect", true, "")
+	branch := client.Database(dbName)
+	source := sourceClient.Database(sourceDBName)
+
+	report.Branch = mongoIdentity(ctx, branch, os.Getenv("DATABASE_URL"))
+	report.Source = mongoIdentity(ctx, source, os.Getenv("SOURCE_DATABASE_URL"))
+	sameServer := report.Branch["server"] == report.Source["server"] &&
+		report.Branch["database"] == report.Source["database"]
+	report.add("distinct_databases", !sameServer,
+		fmt.Sprintf("branch=%s/%s source=%s/%s", report.Branch["server"], report.Branch["database"],
+			report.Source["server"], report.Source["database"]))
+
+	// Counts are taken before the tagged write so app_users is compared as
+	// the branch was handed to us.
+	compareCounts(ctx, report, source, branch)
+
+	res, err := branch.Collection("app_users").InsertOne(ctx, bson.M{"name": report.Tag, "created_at": time.Now()})
+	if err != nil {
+		report.add("branch_write", false, err.Error())
+		return finishReport(report, start)
+	}
+	report.add("branch_write", true, fmt.Sprintf("app_users _id=%v", res.InsertedID))
+
+	onBranch, err := branch.Collection("app_users").CountDocuments(ctx, bson.M{"name": report.Tag})
+	if err != nil {
+		report.add("branch_read_back", false, err.Error())
+	} else {
+		report.add("branch_read_back", onBranch == 1, fmt.Sprintf("%d document(s) on branch", onBranch))
+	}
+
+	// Counting a collection that does not exist returns 0, which is the
+	// answer we want either way.
+	onSource, err := source.Collection("app_users").CountDocuments(ctx, bson.M{"name": report.Tag})
+	if err != nil {
+		report.add("missing_from_source", false, err.Error())
+	} else {
+		report.add("missing_from_source", onSource == 0, fmt.Sprintf("%d document(s) on source", onSource))
+	}
+
+	if os.Getenv("VERIFY_KEEP_ROW") != "true" {
+		if _, err := branch.Collection("app_users").DeleteOne(ctx, bson.M{"_id": res.InsertedID}); err != nil {
+			log.Printf("Warning: failed to remove tagged document %v: %v", res.InsertedID, err)
+		}
+	}
+
+	return finishReport(report, start)
+}
+
+// openSource connects to SOURCE_DATABASE_URL and returns the source database
+// name: SOURCE_MONGODB_DATABASE, else the URI path, else the branch's. The
+// checks only ever read from it (counts), never write.
+func openSource(ctx context.Context, branchDB string) (*mongo.Client, string, error) {
+	sourceURL := os.Getenv("SOURCE_DATABASE_URL")
+	if sourceURL == "" {
+		return nil, "", fmt.Errorf("SOURCE_DATABASE_URL is not set")
+	}
+	log.Printf("Connecting to source database: %s", maskPassword(sourceURL))
+
+	opts, cs, err := clientOptions(sourceURL)
+	if err != nil {
+		return nil, "", err
+	}
+	name := os.Getenv("SOURCE_MONGODB_DATABASE")
+	if name == "" {
+		name = cs.Database
+	}
+	if name == "" {
+		name = branchDB
+	}
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, "", err
+	}
+	return client, name, nil
+}
+
+// mongoIdentity reports the host serverStatus returns. Users without the
+// clusterMonitor role cannot run it, so the hosts from the URI are used as a
+// fallback.
+func mongoIdentity(ctx context.Context, db *mongo.Database, uri string) map[string]string {
+	identity := map[string]string{"database": db.Name()}
+
+	var status struct {
+		Host    string `bson:"host"`
+		Version string `bson:"version"`
+		PID     int64  `bson:"pid"`
+	}
+	err := db.Client().Database("admin").RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).Decode(&status)
+	if err == nil {
+		identity["server"] = status.Host
+		identity["version"] = status.Version
+		identity["pid"] = strconv.FormatInt(status.PID, 10)
+		return identity
+	}
+
+	identity["server"] = strings.Join(options.Client().ApplyURI(uri).Hosts, ",")
+	identity["server_status_error"] = err.Error()
+	return identity
+}
+
+func compareCounts(ctx context.Context, report *isolationReport, source, branch *mongo.Database) {
+	switch report.CountMode {
+	case "report", "match", "empty":
+	default:
+		report.add("document_counts", false, fmt.Sprintf("unknown VERIFY_COUNTS %q (want report, match or empty)", report.CountMode))
+		return
+	}
+
+	expected, err := parseExpectedCounts(os.Getenv("VERIFY_EXPECT_COUNTS"))
+	if err != nil {
+		report.add("document_counts", false, err.Error())
+		return
+	}
+
+	sourceCounts, err := collectionCounts(ctx, source)
+	if err != nil {
+		report.add("document_counts", false, "source: "+err.Error())
+		return
+	}
+	branchCounts, err := collectionCounts(ctx, branch)
+	if err != nil {
+		report.add("document_counts", false, "branch: "+err.Error())
+		return
+	}
+
+	failed := 0
+	for _, name := range sortedKeys(sourceCounts, branchCounts) {
+		tc := collectionCount{Collection: name, Passed: true}
+		if n, ok := sourceCounts[name]; ok {
+			tc.Source = &n
+		}
+		if n, ok := branchCounts[name]; ok {
+			tc.Branch = &n
+		}
+		branchRows := int64(0)
+		if tc.Branch != nil {
+			branchRows = *tc.Branch
+		}
+
+		if want, ok := lookupExpected(expected, name); ok {
+			tc.Expected = &want
+			tc.Passed = tc.Branch != nil && *tc.Branch == want
+		} else if tc.Source != nil {
+			switch report.CountMode {
+			case "match":
+				tc.Passed = tc.Branch != nil && *tc.Branch == *tc.Source
+			case "empty":
+				tc.Passed = branchRows == 0
+			}
+		}
+		if !tc.Passed {
+			failed++
+		}
+		report.Collections = append(report.Collections, tc)
+	}
+	report.add("document_counts", failed == 0, fmt.Sprintf("mode=%s, %d collection(s), %d mismatched", report.CountMode, len(report.Collections), failed))
+}
+
+func collectionCounts(ctx context.Context, db *mongo.Database) (map[string]int64, error) {
+	names, err := db.ListCollectionNames(ctx, bson.M{"type": "collection"})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, "system.") {
+			continue
+		}
+		n, err := db.Collection(name).CountDocuments(ctx, bson.M{})
+		if err != nil {
+			return nil, fmt.Errorf("count %s: %w", name, err)
+		}
+		counts[name] = n
+	}
+	return counts, nil
+}
+
+func parseExpectedCounts(spec string) (map[string]int64, error) {
+	expected := map[string]int64{}
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("VERIFY_EXPECT_COUNTS entry %q must be collection=count", kv)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("VERIFY_EXPECT_COUNTS entry %q: %w", kv, err)
+		}
+		expected[strings.TrimSpace(parts[0])] = n
+	}
+	return expected, nil
+}
+
+// lookupExpected returns the pinned count for a collection, if any.
+func lookupExpected(expected map[string]int64, collection string) (int64, bool) {
+	n, ok := expected[collection]
+	return n, ok
+}
+
+func sortedKeys(a, b map[string]int64) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]int64{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func finishReport(report *isolationReport, start time.Time) int {
+	report.Passed = true
+	for _, c := range report.Checks {
+		if !c.Passed {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Isolation check FAILED")
+		return 1
+	}
+	log.Println("Isolation check passed")
+	return 0
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FIDELITY=setup creates a fixed set of objects in the database it is pointed
+// at (normally the source, through a port-forward): two schemas besides dbo, a
+// table with every common SQL Server type including a computed column and an
+// IDENTITY with a non-default seed, a view, a scalar function and a procedure
+// with an OUTPUT parameter. It also turns on ALLOW_SNAPSHOT_ISOLATION.
+// FIDELITY=check then runs against a branch and reports, per object, whether
+// the branch has it, whether its definition matches the source
+// (SOURCE_DATABASE_URL, optional) and whether it works: typed values round-trip,
+// SET IDENTITY_INSERT takes an explicit id, a SNAPSHOT transaction keeps its
+// view while another session commits, and the view, function and procedure
+// are called. With a source, the seeded row must also be copied value for
+// value; FIDELITY_ROWS=0 skips that for schema-only branches.
+
+// fidelitySetup is executed statement by statement: CREATE SCHEMA, VIEW,
+// FUNCTION and PROCEDURE must each be alone in their batch.
+var fidelitySetup = []string{
+	"DROP PROCEDURE IF EXISTS fidelity_sales.add_item",
+	"DROP FUNCTION IF EXISTS fidelity_ref.convert_amount",
+	"DROP VIEW IF EXISTS fidelity_sales.item_totals",
+	"DROP TABLE IF EXISTS fidelity_sales.items",
+	"DROP TABLE IF EXISTS fidelity_ref.currencies",
+	"DROP SCHEMA IF EXISTS fidelity_sales",
+	"DROP SCHEMA IF EXISTS fidelity_ref",
+	"CREATE SCHEMA fidelity_ref",
+	"CREATE SCHEMA fidelity_sales",
+	`CREATE TABLE fidelity_ref.currencies (
+		code CHAR(3) PRIMARY KEY,
+		name NVARCHAR(50) NOT NULL,
+		rate DECIMAL(18,6) NOT NULL
+	)`,
+	`CREATE TABLE fidelity_sales.items (
+		id INT IDENTITY(1000,5) PRIMARY KEY,
+		guid UNIQUEIDENTIFIER NOT NULL DEFAULT NEWID(),
+		tag NVARCHAR(100) NOT NULL,
+		small SMALLINT NULL,
+		tiny TINYINT NULL,
+		big BIGINT NULL,
+		flag BIT NULL,
+		amount DECIMAL(19,4) NULL,
+		ratio FLOAT NULL,
+		price MONEY NULL,
+		real_val REAL NULL,
+		created_at DATETIMEOFFSET(7) NULL,
+		local_time DATETIME2(7) NULL,
+		day DATE NULL,
+		tod TIME(7) NULL,
+		legacy DATETIME NULL,
+		code CHAR(3) NULL REFERENCES fidelity_ref.currencies(code),
+		ascii VARCHAR(100) NULL,
+		unicode NVARCHAR(MAX) NULL,
+		payload VARBINARY(MAX) NULL,
+		doc XML NULL,
+		row_version ROWVERSION,
+		double_amount AS (amount * 2) PERSISTED
+	)`,
+	`CREATE VIEW fidelity_sales.item_totals AS
+		SELECT tag, COUNT(*) AS items, SUM(amount) AS amount
+		FROM fidelity_sales.items GROUP BY tag`,
+	`CREATE FUNCTION fidelity_ref.convert_amount(@amount DECIMAL(19,4), @code CHAR(3))
+	RETURNS DECIMAL(19,4)
+	AS
+	BEGIN
+		RETURN (SELECT @amount * rate FROM fidelity_ref.currencies WHERE code = @code)
+	END`,
+	`CREATE PROCEDURE fidelity_sales.add_item
+		@tag NVARCHAR(100), @amount DECIMAL(19,4), @code CHAR(3), @new_id INT OUTPUT
+	AS
+	BEGIN
+		SET NOCOUNT ON;
+		INSERT INTO fidelity_sales.items (tag, amount, code, created_at)
+		VALUES (@tag, @amount, @code, SYSDATETIMEOFFSET());
+		SET @new_id = SCOPE_IDENTITY();
+	END`,
+	`INSERT INTO fidelity_ref.currencies (code, name, rate) VALUES
+		('USD', N'US Dollar', 1.0), ('EUR', N'Euro', 1.08)`,
+	`INSERT INTO fidelity_sales.items (guid, tag, small, tiny, big, flag, amount, ratio, price, real_val,
+		created_at, local_time, day, tod, legacy, code, ascii, unicode, payload, doc)
+	VALUES ('6F9619FF-8B86-D011-B42D-00C04FC964FF', N'seed', -12, 200, 9007199254740993, 1,
+		12345.6789, 0.125, 19.99, 1.5,
+		'2024-03-10T12:34:56.1234567+05:30', '2024-03-10T12:34:56.1234567', '2024-03-10',
+		'23:59:59.9999999', '2024-03-10T12:34:56.123', 'EUR', 'plain ascii', N'Grüße ✓ 日本語',
+		0x00FF10EE7F, N'<item sku="A1"><qty>2</qty></item>')`,
+	"ALTER DATABASE CURRENT SET ALLOW_SNAPSHOT_ISOLATION ON",
+}
+
+// fidelityObjects maps kind/name to the query that returns its definition.
+var fidelityObjects = []struct {
+	kind, name, query string
+}{
+	{"schema", "fidelity_ref", "SELECT name FROM sys.schemas WHERE name = 'fidelity_ref'"},
+	{"schema", "fidelity_sales", "SELECT name FROM sys.schemas WHERE name = 'fidelity_sales'"},
+	{"table", "fidelity_ref.currencies", columnsQuery("fidelity_ref.currencies")},
+	{"table", "fidelity_sales.items", columnsQuery("fidelity_sales.items")},
+	{"identity", "fidelity_sales.items.id",
+		`SELECT CONCAT(CAST(seed_value AS BIGINT), ',', CAST(increment_value AS BIGINT)) FROM sys.identity_columns
+		 WHERE object_id = OBJECT_ID('fidelity_sales.items') AND name = 'id'`},
+	{"view", "fidelity_sales.item_totals", "SELECT definition FROM sys.sql_modules WHERE object_id = OBJECT_ID('fidelity_sales.item_totals')"},
+	{"function", "fidelity_ref.convert_amount", "SELECT definition FROM sys.sql_modules WHERE object_id = OBJECT_ID('fidelity_ref.convert_amount')"},
+	{"procedure", "fidelity_sales.add_item", "SELECT definition FROM sys.sql_modules WHERE object_id = OBJECT_ID('fidelity_sales.add_item')"},
+	{"database", "snapshot_isolation",
+		"SELECT snapshot_isolation_state_desc FROM sys.databases WHERE name = DB_NAME()"},
+}
+
+// fidelityRowsQuery renders the seeded row as text so branch and source can
+// be compared value for value.
+const fidelityRowsQuery = `
+	SELECT CONCAT_WS('|', id, CONVERT(NVARCHAR(36), guid), small, tiny, big, flag,
+		CONVERT(VARCHAR(40), amount), CONVERT(VARCHAR(40), ratio, 3), CONVERT(VARCHAR(40), price, 2),
+		CONVERT(VARCHAR(40), real_val, 3), CONVERT(VARCHAR(40), created_at, 127),
+		CONVERT(VARCHAR(40), local_time, 126), CONVERT(VARCHAR(10), day, 23), CONVERT(VARCHAR(20), tod),
+		CONVERT(VARCHAR(30), legacy, 126), code, ascii, unicode, CONVERT(VARCHAR(MAX), payload, 1),
+		CAST(doc AS NVARCHAR(MAX)), CONVERT(VARCHAR(40), double_amount))
+	FROM fidelity_sales.items WHERE tag = N'seed'`
+
+func columnsQuery(table string) string {
+	return fmt.Sprintf(`
+		SELECT STRING_AGG(CAST(CONCAT(c.name, ' ', TYPE_NAME(c.user_type_id), '(', c.max_length, ',', c.precision, ',', c.scale, ')',
+			IIF(c.is_nullable = 1, ' NULL', ' NOT NULL'), IIF(c.is_identity = 1, ' IDENTITY', ''),
+			' ', cc.definition, ' ', dc.definition) AS NVARCHAR(MAX)), '; ') WITHIN GROUP (ORDER BY c.column_id)
+		FROM sys.columns c
+		LEFT JOIN sys.computed_columns cc ON cc.object_id = c.object_id AND cc.column_id = c.column_id
+		LEFT JOIN sys.default_constraints dc ON dc.parent_object_id = c.object_id AND dc.parent_column_id = c.column_id
+		WHERE c.object_id = OBJECT_ID('%s')`, table)
+}
+
+type fidelityObject struct {
+	Kind           string `json:"kind"`
+	Name           string `json:"name"`
+	Exists         bool   `json:"exists"`
+	SameDefinition *bool  `json:"same_definition,omitempty"`
+	Works          bool   `json:"works"`
+	Detail         string `json:"detail,omitempty"`
+}
+
+type fidelityReport struct {
+	Engine     string            `json:"engine"`
+	Passed     bool              `json:"passed"`
+	Tag        string            `json:"tag"`
+	Branch     map[string]string `json:"branch"`
+	Source     map[string]string `json:"source,omitempty"`
+	Objects    []fidelityObject  `json:"objects"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+func runFidelity(db *sql.DB, mode string) int {
+	switch mode {
+	case "setup":
+		for _, stmt := range fidelitySetup {
+			if _, err := db.Exec(stmt); err != nil {
+				log.Printf("Fidelity setup failed on %q: %v", firstLine(stmt), err)
+				return 1
+			}
+		}
+		log.Printf("Created fidelity objects (%d statements)", len(fidelitySetup))
+		return 0
+	case "1", "true", "check":
+		return runFidelityCheck(db)
+	default:
+		log.Printf("Unknown FIDELITY=%s (setup or check)", mode)
+		return 1
+	}
+}
+
+func runFidelityCheck(db *sql.DB) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	report := &fidelityReport{Engine: "mssql", Tag: fmt.Sprintf("fidelity-%d", time.Now().UnixNano())}
+	report.Branch = mssqlIdentity(ctx, db)
+	checkRows := os.Getenv("FIDELITY_ROWS") != "0"
+
+	var sourceDefs map[string]string
+	if os.Getenv("SOURCE_DATABASE_URL") != "" {
+		sourceDB, source, err := openSource(ctx)
+		if err != nil {
+			report.Error = "source: " + err.Error()
+			return finishFidelityReport(report, start)
+		}
+		report.Source = mssqlIdentity(ctx, source)
+		sourceDefs = objectDefinitions(ctx, source, checkRows)
+		source.Close()
+		sourceDB.Close()
+	}
+
+	// Definitions are read before the check writes anything, so the seeded
+	// row is compared as the branch was handed to us.
+	branchDefs := objectDefinitions(ctx, db, checkRows)
+	works := exerciseObjects(ctx, db, report.Tag)
+
+	keys := make([]string, 0, len(fidelityObjects)+1)
+	for _, o := range fidelityObjects {
+		keys = append(keys, o.kind+"/"+o.name)
+	}
+	if checkRows && sourceDefs != nil {
+		keys = append(keys, "rows/fidelity_sales.items")
+		works["rows/fidelity_sales.items"] = nil
+	}
+	for _, key := range keys {
+		kind, name, _ := strings.Cut(key, "/")
+		obj := fidelityObject{Kind: kind, Name: name}
+		def, exists := branchDefs[key]
+		obj.Exists = exists
+		if sourceDefs != nil {
+			same := exists && def == sourceDefs[key]
+			obj.SameDefinition = &same
+			if exists && !same {
+				obj.Detail = fmt.Sprintf("branch %q source %q", def, sourceDefs[key])
+			}
+		}
+		if err, ran := works[key]; ran {
+			obj.Works = exists && err == nil
+			if err != nil {
+				obj.Detail = strings.TrimSpace(obj.Detail + " " + err.Error())
+			}
+		} else {
+			// Nothing to exercise: existing is working.
+			obj.Works = exists
+		}
+		report.Objects = append(report.Objects, obj)
+	}
+	return finishFidelityReport(report, start)
+}
+
+func objectDefinitions(ctx context.Context, q querier, rows bool) map[string]string {
+	defs := map[string]string{}
+	for _, o := range fidelityObjects {
+		var def sql.NullString
+		if err := q.QueryRowContext(ctx, o.query).Scan(&def); err != nil || !def.Valid {
+			continue
+		}
+		defs[o.kind+"/"+o.name] = strings.Join(strings.Fields(def.String), " ")
+	}
+	if rows {
+		var row string
+		if err := q.QueryRowContext(ctx, fidelityRowsQuery).Scan(&row); err == nil {
+			defs["rows/fidelity_sales.items"] = row
+		}
+	}
+	return defs
+}
+
+// exerciseObjects writes tagged rows through the objects on the branch and
+// returns a result per kind/name.
+func exerciseObjects(ctx context.Context, db *sql.DB, tag string) map[string]error {
+	results := map[string]error{}
+	results["table/fidelity_sales.items"] = roundTripTypes(ctx, db, tag)
+	results["identity/fidelity_sales.items.id"] = identityInsert(ctx, db, tag)
+	results["database/snapshot_isolation"] = snapshotIsolation(ctx, db, tag)
+
+	var converted string
+	err := db.QueryRowContext(ctx, "SELECT CONVERT(VARCHAR(40), fidelity_ref.convert_amount(100, 'EUR'))").Scan(&converted)
+	results["function/fidelity_ref.convert_amount"] = expect(err, converted == "108.0000", "convert_amount(100, 'EUR') = %s, want 108.0000", converted)
+
+	var newID sql.NullInt64
+	_, err = db.ExecContext(ctx, "fidelity_sales.add_item",
+		sql.Named("tag", tag+"-proc"), sql.Named("amount", "5.5"), sql.Named("code", "USD"),
+		sql.Named("new_id", sql.Out{Dest: &newID}))
+	results["procedure/fidelity_sales.add_item"] = expect(err, newID.Valid && newID.Int64 >= 1000, "OUTPUT parameter = %v", newID)
+
+	var items int
+	err = db.QueryRowContext(ctx, "SELECT items FROM fidelity_sales.item_totals WHERE tag = @p1", tag).Scan(&items)
+	results["view/fidelity_sales.item_totals"] = expect(err, items >= 1, "view counts %d rows for the tag", items)
+	return results
+}
+
+// roundTripTypes inserts one value of every type as a parameter and compares
+// what comes back.
+func roundTripTypes(ctx context.Context, db *sql.DB, tag string) error {
+	createdAt := time.Date(2024, 3, 10, 12, 34, 56, 123456700, time.FixedZone("", 5*3600+1800))
+	payload := bytes.Repeat([]byte{0x00, 0xFF, 0x10, 0xEE}, 64*1024) // 256 KiB, past the 8000-byte limit
+	const (
+		guid    = "6F9619FF-8B86-D011-B42D-00C04FC964FF"
+		unicode = "Grüße ✓ 日本語"
+		doc     = `<item sku="B2"><qty>7</qty></item>`
+	)
+
+	var id int64
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO fidelity_sales.items (guid, tag, small, tiny, big, flag, amount, ratio, price, real_val,
+			created_at, local_time, day, tod, legacy, code, ascii, unicode, payload, doc)
+		OUTPUT INSERTED.id
+		VALUES (@p1, @p2, -12, 200, @p3, 1, @p4, @p5, @p6, 1.5, @p7,
+			'2024-03-10T12:34:56.1234567', '2024-03-10', '23:59:59.9999999', '2024-03-10T12:34:56.123',
+			'USD', 'plain ascii', @p8, @p9, @p10)`,
+		guid, tag, int64(9007199254740993), "12345.6789", 0.125, "19.99", createdAt, unicode, payload, doc,
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	var (
+		gotGUID, amount, price, localTime, day, tod string
+		gotUnicode, gotDoc, double                  string
+		small                                       int16
+		tiny                                        uint8
+		big                                         int64
+		flag                                        bool
+		ratio                                       float64
+		realVal                                     float32
+		gotCreated                                  time.Time
+		gotPayload                                  []byte
+	)
+	err = db.QueryRowContext(ctx, `
+		SELECT CONVERT(NVARCHAR(36), guid), small, tiny, big, flag, CONVERT(VARCHAR(40), amount), ratio,
+			CONVERT(VARCHAR(40), price, 2), real_val, created_at, CONVERT(VARCHAR(40), local_time, 126),
+			CONVERT(VARCHAR(10), day, 23), CONVERT(VARCHAR(20), tod), unicode, payload,
+			CAST(doc AS NVARCHAR(MAX)), CONVERT(VARCHAR(40), double_amount)
+		FROM fidelity_sales.items WHERE id = @p1`, id,
+	).Scan(&gotGUID, &small, &tiny, &big, &flag, &amount, &ratio, &price, &realVal, &gotCreated,
+		&localTime, &day, &tod, &gotUnicode, &gotPayload, &gotDoc, &double)
+	if err != nil {
+		return err
+	}
+
+	var mismatched []string
+	compare := func(name string, ok bool, got any) {
+		if !ok {
+			mismatched = append(mismatched, fmt.Sprintf("%s=%v", name, got))
+		}
+	}
+	_, offset := gotCreated.Zone()
+	compare("uniqueidentifier", strings.EqualFold(gotGUID, guid), gotGUID)
+	compare("smallint", small == -12, small)
+	compare("tinyint", tiny == 200, tiny)
+	compare("bigint", big == 9007199254740993, big)
+	compare("bit", flag, flag)
+	compare("decimal", amount == "12345.6789", amount)
+	compare("float", ratio == 0.125, ratio)
+	compare("money", price == "19.9900", price)
+	compare("real", realVal == 1.5, realVal)
+	compare("datetimeoffset", gotCreated.Equal(createdAt) && offset == 5*3600+1800, gotCreated.Format(time.RFC3339Nano))
+	compare("datetime2", localTime == "2024-03-10T12:34:56.1234567", localTime)
+	compare("date", day == "2024-03-10", day)
+	compare("time", tod == "23:59:59.9999999", tod)
+	compare("nvarchar(max)", gotUnicode == unicode, gotUnicode)
+	compare("varbinary(max)", bytes.Equal(gotPayload, payload), fmt.Sprintf("%d bytes", len(gotPayload)))
+	compare("xml", gotDoc == doc, gotDoc)
+	compare("computed", double == "24691.3578", double)
+	if len(mismatched) > 0 {
+		return fmt.Errorf("values changed: %s", strings.Join(mismatched, ", "))
+	}
+	return nil
+}
+
+// identityInsert inserts an explicit id under SET IDENTITY_INSERT and checks
+// that the next generated id continues after it. The SET and the INSERT share
+// one batch, so they run on the same session.
+func identityInsert(ctx context.Context, db *sql.DB, tag string) error {
+	var maxID int64
+	if err := db.QueryRowContext(ctx, "SELECT ISNULL(MAX(id), 0) FROM fidelity_sales.items").Scan(&maxID); err != nil {
+		return err
+	}
+	explicit := maxID + 1000
+
+	if _, err := db.ExecContext(ctx, `
+		SET IDENTITY_INSERT fidelity_sales.items ON;
+		INSERT INTO fidelity_sales.items (id, tag) VALUES (@p1, @p2);
+		SET IDENTITY_INSERT fidelity_sales.items OFF;`, explicit, tag+"-explicit"); err != nil {
+		return fmt.Errorf("explicit id %d: %w", explicit, err)
+	}
+	var next int64
+	if err := db.QueryRowContext(ctx,
+		"INSERT INTO fidelity_sales.items (tag) OUTPUT INSERTED.id VALUES (@p1)", tag+"-after",
+	).Scan(&next); err != nil {
+		return err
+	}
+	return expect(nil, next == explicit+5, "id after explicit %d is %d, want %d", explicit, next, explicit+5)
+}
+
+// snapshotIsolation opens a SNAPSHOT transaction, commits a row from another
+// session, and checks the transaction still does not see it.
+func snapshotIsolation(ctx context.Context, db *sql.DB, tag string) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSnapshot})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	count := func(q querier) (int, error) {
+		var n int
+		err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM fidelity_sales.items WHERE tag = @p1", tag+"-snapshot").Scan(&n)
+		return n, err
+	}
+	before, err := count(tx)
+	if err != nil {
+		return fmt.Errorf("read in snapshot transaction: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO fidelity_sales.items (tag) VALUES (@p1)", tag+"-snapshot"); err != nil {
+		return fmt.Errorf("concurrent insert: %w", err)
+	}
+	during, err := count(tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	after, err := count(db)
+	if err != nil {
+		return err
+	}
+	return expect(nil, before == 0 && during == 0 && after == 1,
+		"rows seen before/during/after the concurrent commit: %d/%d/%d, want 0/0/1", before, during, after)
+}
+
+func expect(err error, ok bool, format string, args ...any) error {
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf(format, args...)
+	}
+	return nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+func finishFidelityReport(report *fidelityReport, start time.Time) int {
+	sort.SliceStable(report.Objects, func(i, j int) bool { return report.Objects[i].Kind < report.Objects[j].Kind })
+	report.Passed = report.Error == "" && len(report.Objects) > 0
+	for _, o := range report.Objects {
+		if !o.Exists || !o.Works || (o.SameDefinition != nil && !*o.SameDefinition) {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Fidelity check FAILED")
+		return 1
+	}
+	log.Println("Fidelity check passed")
+	return 0
+}
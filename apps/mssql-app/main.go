@@ -2,9 +2,7 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
@@ -23,7 +21,10 @@ func main() {
 
 	log.Printf("Connecting to database: %s", maskPassword(dbURL))
 
-	connStr := toSQLServerURL(dbURL)
+	connStr, err := toSQLServerURL(dbURL)
+	if err != nil {
+		log.Fatalf("Invalid DATABASE_URL: %v", err)
+	}
 
 	db, err := sql.Open("sqlserver", connStr)
 	if err != nil {
@@ -49,6 +50,40 @@ func main() {
 
 	log.Println("Connected to MSSQL database")
 
+	// One-shot modes run instead of the normal startup and exit.
+	if isVerifyMode() {
+		code := runIsolationCheck(db)
+		db.Close()
+		os.Exit(code)
+	}
+	if isSchemaDiffMode() {
+		code := runSchemaDiff(db)
+		db.Close()
+		os.Exit(code)
+	}
+	if spec := os.Getenv("VERIFY_COPY_SPEC"); spec != "" {
+		code := runCopySpecCheck(db, spec)
+		db.Close()
+		os.Exit(code)
+	}
+	if isStressMode() {
+		code := runStress(db)
+		db.Close()
+		os.Exit(code)
+	}
+	if mode := os.Getenv("FIDELITY"); mode != "" {
+		code := runFidelity(db, mode)
+		db.Close()
+		os.Exit(code)
+	}
+	if direction := os.Getenv("MIGRATE"); direction != "" {
+		if err := runMigrations(db, direction); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		db.Close()
+		os.Exit(0)
+	}
+
 	createTableQuery := `
 		IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'app_users')
 		CREATE TABLE app_users (
@@ -110,44 +145,3 @@ func main() {
 	<-sigChan
 	log.Println("Shutting down...")
 }
-
-// toSQLServerURL converts mssql:// URLs to sqlserver:// which is what the
-// go-mssqldb driver expects. If the URL is already sqlserver://, it's
-// returned as-is.
-func toSQLServerURL(rawURL string) string {
-	parsed, err := url.Parse(rawURL)
-	if err != nil {
-		log.Printf("Warning: could not parse URL, using as-is: %v", err)
-		return rawURL
-	}
-
-	if parsed.Scheme == "mssql" {
-		parsed.Scheme = "sqlserver"
-	}
-
-	// go-mssqldb wants the database name as a query param, not as a path
-	if parsed.Path != "" && parsed.Path != "/" {
-		dbName := parsed.Path[1:]
-		q := parsed.Query()
-		if q.Get("database") == "" {
-			q.Set("database", dbName)
-		}
-		parsed.RawQuery = q.Encode()
-		parsed.Path = ""
-	}
-
-	return parsed.String()
-}
-
-func maskPassword(dsn string) string {
-	parsed, err := url.Parse(dsn)
-	if err != nil {
-		return dsn
-	}
-	if parsed.User != nil {
-		if _, hasPass := parsed.User.Password(); hasPass {
-			parsed.User = url.UserPassword(parsed.User.Username(), "****")
-		}
-	}
-	return fmt.Sprintf("%s://%s@%s%s", parsed.Scheme, parsed.User, parsed.Host, parsed.Path)
-}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MIGRATE=up|down|status runs the SQL files in migrations/ against
+// DATABASE_URL and exits. Files are named <version>_<name>.up.sql and
+// <version>_<name>.down.sql and applied in version order; applied versions are
+// recorded in schema_migrations. MIGRATIONS_DIR replaces the embedded set and
+// MIGRATE_STEPS sets how many versions `down` rolls back (default 1). A line
+// containing only GO splits a file into batches, as in sqlcmd.
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+var (
+	migrationFile  = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+	batchSeparator = regexp.MustCompile(`(?im)^\s*GO\s*$`)
+)
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.version, m.name)
+}
+
+func loadMigrations() ([]migration, error) {
+	var fsys fs.FS
+	if dir := os.Getenv("MIGRATIONS_DIR"); dir != "" {
+		fsys = os.DirFS(dir)
+	} else {
+		sub, err := fs.Sub(embeddedMigrations, "migrations")
+		if err != nil {
+			return nil, err
+		}
+		fsys = sub
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	byVersion := map[int64]*migration{}
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		} else if m.name != match[2] {
+			return nil, fmt.Errorf("version %d is used by both %s and %s", version, m.name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %s has no up file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+func runMigrations(db *sql.DB, direction string) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		IF OBJECT_ID('schema_migrations', 'U') IS NULL
+		CREATE TABLE schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name NVARCHAR(255) NOT NULL,
+			applied_at DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME()
+		)
+	`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	switch direction {
+	case "up":
+		return migrateUp(db, migrations, applied)
+	case "down":
+		steps, err := strconv.Atoi(getEnvDefault("MIGRATE_STEPS", "1"))
+		if err != nil || steps < 1 {
+			return fmt.Errorf("MIGRATE_STEPS must be a positive integer")
+		}
+		return migrateDown(db, migrations, applied, steps)
+	case "status":
+		for _, m := range migrations {
+			state := "pending"
+			if at, ok := applied[m.version]; ok {
+				state = "applied " + at.Format(time.RFC3339)
+			}
+			log.Printf("  %s: %s", m, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown MIGRATE %q (want up, down or status)", direction)
+	}
+}
+
+func appliedVersions(db *sql.DB) (map[int64]time.Time, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// migrateUp applies every pending migration, each in its own transaction so a
+// failing file leaves no partial schema behind.
+func migrateUp(db *sql.DB, migrations []migration, applied map[int64]time.Time) error {
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		pending++
+		start := time.Now()
+		err := inTx(db, func(tx *sql.Tx) error {
+			if err := execBatches(tx, m.up); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (@p1, @p2)", m.version, m.name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("apply %s: %w", m, err)
+		}
+		log.Printf("Applied %s (%s)", m, time.Since(start).Round(time.Millisecond))
+	}
+	if pending == 0 {
+		log.Println("No pending migrations")
+	}
+	return nil
+}
+
+func migrateDown(db *sql.DB, migrations []migration, applied map[int64]time.Time, steps int) error {
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		if m.down == "" {
+			return fmt.Errorf("migration %s has no down file", m)
+		}
+		start := time.Now()
+		err := inTx(db, func(tx *sql.Tx) error {
+			if err := execBatches(tx, m.down); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = @p1", m.version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("roll back %s: %w", m, err)
+		}
+		log.Printf("Rolled back %s (%s)", m, time.Since(start).Round(time.Millisecond))
+		steps--
+	}
+	return nil
+}
+
+// execBatches runs each GO-separated batch of a file. Statements such as
+// CREATE SCHEMA or CREATE VIEW must be the only statement in their batch.
+func execBatches(tx *sql.Tx, script string) error {
+	for i, batch := range batchSeparator.Split(script, -1) {
+		if strings.TrimSpace(batch) == "" {
+			continue
+		}
+		if _, err := tx.Exec(batch); err != nil {
+			return fmt.Errorf("batch %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func inTx(db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func getEnvDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,4 @@
+DROP TABLE dbo.catalog_products;
+DROP TABLE dbo.categories;
+DROP SEQUENCE dbo.sku_seq;
+DROP TYPE dbo.cents;
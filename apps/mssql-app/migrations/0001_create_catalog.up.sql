@@ -0,0 +1,28 @@
+CREATE TYPE dbo.cents FROM INT NOT NULL;
+GO
+
+CREATE SEQUENCE dbo.sku_seq AS INT START WITH 1000 INCREMENT BY 10;
+GO
+
+CREATE TABLE dbo.categories (
+    id INT IDENTITY(1,1) CONSTRAINT pk_categories PRIMARY KEY,
+    parent_id INT NULL CONSTRAINT fk_categories_parent REFERENCES dbo.categories (id),
+    slug NVARCHAR(100) NOT NULL CONSTRAINT uq_categories_slug UNIQUE,
+    name NVARCHAR(255) NOT NULL
+);
+
+CREATE TABLE dbo.catalog_products (
+    id BIGINT IDENTITY(1,1) CONSTRAINT pk_catalog_products PRIMARY KEY,
+    sku NVARCHAR(32) NOT NULL
+        CONSTRAINT df_catalog_products_sku DEFAULT (CONCAT('SKU-', NEXT VALUE FOR dbo.sku_seq))
+        CONSTRAINT uq_catalog_products_sku UNIQUE,
+    category_id INT NOT NULL CONSTRAINT fk_catalog_products_category REFERENCES dbo.categories (id),
+    name NVARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL CONSTRAINT df_catalog_products_status DEFAULT ('draft')
+        CONSTRAINT ck_catalog_products_status CHECK (status IN ('draft', 'active', 'discontinued')),
+    price_cents dbo.cents CONSTRAINT ck_catalog_products_price CHECK (price_cents >= 0),
+    created_at DATETIME2(3) NOT NULL CONSTRAINT df_catalog_products_created DEFAULT (SYSUTCDATETIME())
+);
+
+CREATE INDEX ix_catalog_products_category ON dbo.catalog_products (category_id, status);
+CREATE INDEX ix_catalog_products_active ON dbo.catalog_products (name) INCLUDE (price_cents) WHERE status = 'active';
@@ -0,0 +1,3 @@
+DROP TABLE dbo.order_items;
+DROP TABLE dbo.customer_orders;
+DROP TABLE dbo.customers;
@@ -0,0 +1,28 @@
+CREATE TABLE dbo.customers (
+    id BIGINT IDENTITY(1,1) CONSTRAINT pk_customers PRIMARY KEY,
+    email NVARCHAR(255) NOT NULL CONSTRAINT uq_customers_email UNIQUE,
+    full_name NVARCHAR(255) NOT NULL,
+    country CHAR(2) NOT NULL CONSTRAINT df_customers_country DEFAULT ('US'),
+    created_at DATETIME2 NOT NULL CONSTRAINT df_customers_created DEFAULT (SYSUTCDATETIME())
+);
+
+CREATE TABLE dbo.customer_orders (
+    id BIGINT IDENTITY(1,1) CONSTRAINT pk_customer_orders PRIMARY KEY,
+    customer_id BIGINT NOT NULL CONSTRAINT fk_customer_orders_customer REFERENCES dbo.customers (id),
+    status VARCHAR(20) NOT NULL CONSTRAINT df_customer_orders_status DEFAULT ('pending')
+        CONSTRAINT ck_customer_orders_status CHECK (status IN ('pending', 'paid', 'shipped', 'cancelled')),
+    placed_at DATETIME2 NOT NULL CONSTRAINT df_customer_orders_placed DEFAULT (SYSUTCDATETIME()),
+    shipped_at DATETIME2 NULL,
+    CONSTRAINT ck_customer_orders_shipped_after_placed CHECK (shipped_at IS NULL OR shipped_at >= placed_at)
+);
+
+CREATE INDEX ix_customer_orders_customer ON dbo.customer_orders (customer_id, placed_at DESC);
+
+CREATE TABLE dbo.order_items (
+    order_id BIGINT NOT NULL CONSTRAINT fk_order_items_order REFERENCES dbo.customer_orders (id) ON DELETE CASCADE,
+    product_id BIGINT NOT NULL CONSTRAINT fk_order_items_product REFERENCES dbo.catalog_products (id),
+    quantity INT NOT NULL CONSTRAINT ck_order_items_quantity CHECK (quantity > 0),
+    unit_price_cents INT NOT NULL,
+    line_total_cents AS (quantity * unit_price_cents) PERSISTED,
+    CONSTRAINT pk_order_items PRIMARY KEY (order_id, product_id)
+);
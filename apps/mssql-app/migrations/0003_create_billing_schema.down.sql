@@ -0,0 +1,5 @@
+DROP TABLE billing.invoices;
+DROP SEQUENCE billing.invoice_number_seq;
+GO
+
+DROP SCHEMA billing;
@@ -0,0 +1,18 @@
+CREATE SCHEMA billing;
+GO
+
+CREATE SEQUENCE billing.invoice_number_seq AS INT START WITH 50000 INCREMENT BY 1 MAXVALUE 99999999 NO CYCLE;
+GO
+
+CREATE TABLE billing.invoices (
+    id BIGINT IDENTITY(1,1) CONSTRAINT pk_invoices PRIMARY KEY,
+    number INT NOT NULL
+        CONSTRAINT df_invoices_number DEFAULT (NEXT VALUE FOR billing.invoice_number_seq)
+        CONSTRAINT uq_invoices_number UNIQUE,
+    order_id BIGINT NOT NULL CONSTRAINT fk_invoices_order REFERENCES dbo.customer_orders (id),
+    amount_cents BIGINT NOT NULL CONSTRAINT ck_invoices_amount_positive CHECK (amount_cents > 0),
+    currency CHAR(3) NOT NULL CONSTRAINT df_invoices_currency DEFAULT ('USD'),
+    issued_at DATETIME2 NOT NULL CONSTRAINT df_invoices_issued DEFAULT (SYSUTCDATETIME())
+);
+
+CREATE INDEX ix_invoices_order ON billing.invoices (order_id);
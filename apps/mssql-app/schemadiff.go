@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SCHEMA_DIFF=1 compares the schema of the branch (DATABASE_URL) with the
+// source (SOURCE_DATABASE_URL) and prints a JSON report: tables, columns,
+// indexes, constraints, sequences and types that exist on only one side or
+// are defined differently. It exits 0 when the schemas are identical.
+// SCHEMA_DIFF_IGNORE is a CSV of object names (e.g. dbo.app_users) to leave
+// out, together with everything that belongs to them.
+
+type schemaDiff struct {
+	OnlyInBranch []string        `json:"only_in_branch"`
+	OnlyInSource []string        `json:"only_in_source"`
+	Different    []objectChanged `json:"different"`
+	BranchCount  int             `json:"branch_count"`
+	SourceCount  int             `json:"source_count"`
+}
+
+type objectChanged struct {
+	Name   string `json:"name"`
+	Branch string `json:"branch"`
+	Source string `json:"source"`
+}
+
+type schemaDiffReport struct {
+	Engine     string                 `json:"engine"`
+	Identical  bool                   `json:"identical"`
+	Branch     map[string]string      `json:"branch"`
+	Source     map[string]string      `json:"source"`
+	Categories map[string]*schemaDiff `json:"categories"`
+}
+
+// schemaQueries return (name, definition) pairs per category. SQL Server has
+// no enums, so user-defined alias and table types are compared under "types"
+// instead. CONCAT treats NULL as an empty string, which keeps the optional
+// parts of each definition out of the way.
+var schemaQueries = map[string]string{
+	"tables": `
+		SELECT s.name + '.' + t.name, t.type_desc
+		FROM sys.tables t
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		WHERE t.is_ms_shipped = 0`,
+	"columns": `
+		SELECT s.name + '.' + t.name + '.' + c.name,
+		       CONCAT(ty.name, '(', c.max_length, ',', c.precision, ',', c.scale, ')',
+		              CASE WHEN c.is_nullable = 0 THEN ' NOT NULL' END,
+		              CASE WHEN c.is_identity = 1 THEN ' IDENTITY' END,
+		              ' DEFAULT ' + dc.definition,
+		              ' AS ' + cc.definition,
+		              CASE WHEN cc.is_persisted = 1 THEN ' PERSISTED' END,
+		              ' COLLATE ' + c.collation_name)
+		FROM sys.columns c
+		JOIN sys.tables t ON t.object_id = c.object_id
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		JOIN sys.types ty ON ty.user_type_id = c.user_type_id
+		LEFT JOIN sys.default_constraints dc ON dc.object_id = c.default_object_id
+		LEFT JOIN sys.computed_columns cc ON cc.object_id = c.object_id AND cc.column_id = c.column_id
+		WHERE t.is_ms_shipped = 0`,
+	"indexes": `
+		SELECT s.name + '.' + t.name + '.' + i.name,
+		       CONCAT(i.type_desc,
+		              CASE WHEN i.is_unique = 1 THEN ' UNIQUE' END,
+		              CASE WHEN i.is_primary_key = 1 THEN ' PRIMARY KEY' END,
+		              ' (',
+		              STUFF((SELECT ',' + c.name + CASE WHEN ic.is_descending_key = 1 THEN ' DESC' ELSE '' END
+		                     FROM sys.index_columns ic
+		                     JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+		                     WHERE ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.is_included_column = 0
+		                     ORDER BY ic.key_ordinal
+		                     FOR XML PATH('')), 1, 1, ''),
+		              ')',
+		              ' INCLUDE (' + STUFF((SELECT ',' + c.name
+		                     FROM sys.index_columns ic
+		                     JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+		                     WHERE ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.is_included_column = 1
+		                     ORDER BY c.name
+		                     FOR XML PATH('')), 1, 1, '') + ')',
+		              ' WHERE ' + i.filter_definition)
+		FROM sys.indexes i
+		JOIN sys.tables t ON t.object_id = i.object_id
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		WHERE i.type > 0 AND t.is_ms_shipped = 0`,
+	"constraints": `
+		SELECT s.name + '.' + t.name + '.' + kc.name, CAST(kc.type_desc AS NVARCHAR(MAX))
+		FROM sys.key_constraints kc
+		JOIN sys.tables t ON t.object_id = kc.parent_object_id
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		UNION ALL
+		SELECT s.name + '.' + t.name + '.' + fk.name,
+		       CONCAT('FOREIGN KEY REFERENCES ', OBJECT_SCHEMA_NAME(fk.referenced_object_id), '.',
+		              OBJECT_NAME(fk.referenced_object_id),
+		              ' ON DELETE ', fk.delete_referential_action_desc,
+		              ' ON UPDATE ', fk.update_referential_action_desc)
+		FROM sys.foreign_keys fk
+		JOIN sys.tables t ON t.object_id = fk.parent_object_id
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		UNION ALL
+		SELECT s.name + '.' + t.name + '.' + ck.name, CONCAT('CHECK ', ck.definition)
+		FROM sys.check_constraints ck
+		JOIN sys.tables t ON t.object_id = ck.parent_object_id
+		JOIN sys.schemas s ON s.schema_id = t.schema_id`,
+	"sequences": `
+		SELECT s.name + '.' + seq.name,
+		       CONCAT(TYPE_NAME(seq.user_type_id),
+		              ' start ', CAST(seq.start_value AS NVARCHAR(40)),
+		              ' increment ', CAST(seq.increment AS NVARCHAR(40)),
+		              ' min ', CAST(seq.minimum_value AS NVARCHAR(40)),
+		              ' max ', CAST(seq.maximum_value AS NVARCHAR(40)),
+		              ' cycle ', seq.is_cycling)
+		FROM sys.sequences seq
+		JOIN sys.schemas s ON s.schema_id = seq.schema_id`,
+	"types": `
+		SELECT s.name + '.' + t.name,
+		       CONCAT(TYPE_NAME(t.system_type_id), '(', t.max_length, ',', t.precision, ',', t.scale, ')',
+		              CASE WHEN t.is_nullable = 0 THEN ' NOT NULL' END,
+		              CASE WHEN t.is_table_type = 1 THEN ' TABLE TYPE' END)
+		FROM sys.types t
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		WHERE t.is_user_defined = 1`,
+}
+
+func isSchemaDiffMode() bool {
+	v := os.Getenv("SCHEMA_DIFF")
+	return v == "1" || v == "true"
+}
+
+func runSchemaDiff(branch querier) int {
+	ctx := context.Background()
+	sourceDB, source, err := openSource(ctx)
+	if err != nil {
+		log.Printf("Failed to connect to source: %v", err)
+		return 1
+	}
+	defer sourceDB.Close()
+	defer source.Close()
+
+	report := &schemaDiffReport{
+		Engine:     "mssql",
+		Identical:  true,
+		Branch:     mssqlIdentity(ctx, branch),
+		Source:     mssqlIdentity(ctx, source),
+		Categories: map[string]*schemaDiff{},
+	}
+	ignore := splitCSV(os.Getenv("SCHEMA_DIFF_IGNORE"))
+
+	for category, query := range schemaQueries {
+		branchObjects, err := schemaObjects(ctx, branch, query, ignore)
+		if err != nil {
+			log.Printf("Failed to read %s from branch: %v", category, err)
+			return 1
+		}
+		sourceObjects, err := schemaObjects(ctx, source, query, ignore)
+		if err != nil {
+			log.Printf("Failed to read %s from source: %v", category, err)
+			return 1
+		}
+		diff := diffObjects(branchObjects, sourceObjects)
+		if len(diff.OnlyInBranch)+len(diff.OnlyInSource)+len(diff.Different) > 0 {
+			report.Identical = false
+		}
+		report.Categories[category] = diff
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Identical {
+		log.Println("Schemas differ")
+		return 1
+	}
+	log.Println("Schemas are identical")
+	return 0
+}
+
+func schemaObjects(ctx context.Context, q querier, query string, ignore []string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := map[string]string{}
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			return nil, err
+		}
+		if !ignored(name, ignore) {
+			objects[name] = definition
+		}
+	}
+	return objects, rows.Err()
+}
+
+func diffObjects(branch, source map[string]string) *schemaDiff {
+	diff := &schemaDiff{
+		OnlyInBranch: []string{},
+		OnlyInSource: []string{},
+		Different:    []objectChanged{},
+		BranchCount:  len(branch),
+		SourceCount:  len(source),
+	}
+	for name, def := range branch {
+		srcDef, ok := source[name]
+		switch {
+		case !ok:
+			diff.OnlyInBranch = append(diff.OnlyInBranch, name)
+		case srcDef != def:
+			diff.Different = append(diff.Different, objectChanged{Name: name, Branch: def, Source: srcDef})
+		}
+	}
+	for name := range source {
+		if _, ok := branch[name]; !ok {
+			diff.OnlyInSource = append(diff.OnlyInSource, name)
+		}
+	}
+	sort.Strings(diff.OnlyInBranch)
+	sort.Strings(diff.OnlyInSource)
+	sort.Slice(diff.Different, func(i, j int) bool { return diff.Different[i].Name < diff.Different[j].Name })
+	return diff
+}
+
+// ignored reports whether name is one of the ignored objects or belongs to
+// one (dbo.app_users also drops dbo.app_users.id and its indexes).
+func ignored(name string, ignore []string) bool {
+	for _, prefix := range ignore {
+		if name == prefix || strings.HasPrefix(name, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func splitCSV(csv string) []string {
+	var items []string
+	for _, s := range strings.Split(csv, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
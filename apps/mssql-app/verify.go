@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VERIFY_ISOLATION=1 turns the app into a one-shot branch check: it writes a
+// tagged row to the branch (DATABASE_URL), confirms the row is not visible in
+// the source (SOURCE_DATABASE_URL), compares per-table row counts and prints a
+// JSON report. The process exits 0 when every check passed and 1 otherwise.
+//
+// VERIFY_COUNTS decides how row counts are judged:
+//
+//	report  record counts only (default)
+//	match   every source table has the same count on the branch (copy mode all)
+//	empty   every source table is missing or empty on the branch (empty/schema)
+//
+// VERIFY_EXPECT_COUNTS=users=3,orders=4 pins exact branch counts for filtered
+// copies and takes precedence over VERIFY_COUNTS for the tables it names.
+
+type check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type tableCount struct {
+	Table    string `json:"table"`
+	Source   *int64 `json:"source"`
+	Branch   *int64 `json:"branch"`
+	Expected *int64 `json:"expected,omitempty"`
+	Passed   bool   `json:"passed"`
+}
+
+type isolationReport struct {
+	Engine     string            `json:"engine"`
+	Passed     bool              `json:"passed"`
+	Tag        string            `json:"tag"`
+	CountMode  string            `json:"count_mode"`
+	Branch     map[string]string `json:"branch"`
+	Source     map[string]string `json:"source"`
+	Checks     []check           `json:"checks"`
+	Tables     []tableCount      `json:"tables"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+func (r *isolationReport) add(name string, passed bool, detail string) {
+	r.Checks = append(r.Checks, check{Name: name, Passed: passed, Detail: detail})
+}
+
+// querier is satisfied by both *sql.DB and the pinned *sql.Conn used for the
+// source.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func isVerifyMode() bool {
+	v := os.Getenv("VERIFY_ISOLATION")
+	return v == "1" || v == "true"
+}
+
+// runIsolationCheck runs the checks against an already connected branch and
+// returns the exit code.
+func runIsolationCheck(branch *sql.DB) int {
+	ctx := context.Background()
+	start := time.Now()
+	report := &isolationReport{
+		Engine:    "mssql",
+		Tag:       fmt.Sprintf("isolation-check-%d", time.Now().UnixNano()),
+		CountMode: os.Getenv("VERIFY_COUNTS"),
+		Tables:    []tableCount{},
+	}
+	if report.CountMode == "" {
+		report.CountMode = "report"
+	}
+
+	sourceDB, source, err := openSource(ctx)
+	if err != nil {
+		report.add("source_connect", false, err.Error())
+		return finishReport(report, start)
+	}
+	defer sourceDB.Close()
+	defer source.Close()
+	report.add("source_connect", true, "")
+
+	report.Branch = mssqlIdentity(ctx, branch)
+	report.Source = mssqlIdentity(ctx, source)
+	sameServer := report.Branch["server"] == report.Source["server"] &&
+		report.Branch["database"] == report.Source["database"]
+	report.add("distinct_databases", !sameServer,
+		fmt.Sprintf("branch=%s/%s source=%s/%s", report.Branch["server"], report.Branch["database"],
+			report.Source["server"], report.Source["database"]))
+
+	// Counts are taken before the tagged write so app_users is compared as
+	// the branch was handed to us.
+	compareCounts(ctx, report, source, branch)
+
+	if _, err := branch.Exec(`
+		IF NOT EXISTS (SELECT * FROM sys.tables WHERE name = 'app_users')
+		CREATE TABLE app_users (
+			id INT IDENTITY(1,1) PRIMARY KEY,
+			name NVARCHAR(255) NOT NULL,
+			created_at DATETIME2 DEFAULT GETDATE()
+		)
+	`); err != nil {
+		report.add("branch_write", false, err.Error())
+		return finishReport(report, start)
+	}
+
+	var id int64
+	if err := branch.QueryRow("INSERT INTO app_users (name) OUTPUT INSERTED.id VALUES (@p1)", report.Tag).Scan(&id); err != nil {
+		report.add("branch_write", false, err.Error())
+		return finishReport(report, start)
+	}
+	report.add("branch_write", true, fmt.Sprintf("app_users id=%d", id))
+
+	var onBranch int
+	if err := branch.QueryRow("SELECT COUNT(*) FROM app_users WHERE name = @p1", report.Tag).Scan(&onBranch); err != nil {
+		report.add("branch_read_back", false, err.Error())
+	} else {
+		report.add("branch_read_back", onBranch == 1, fmt.Sprintf("%d row(s) on branch", onBranch))
+	}
+
+	// A source without app_users cannot hold the row either.
+	var onSource, exists int
+	if err := source.QueryRowContext(ctx,
+		"SELECT CASE WHEN OBJECT_ID('app_users', 'U') IS NULL THEN 0 ELSE 1 END",
+	).Scan(&exists); err != nil {
+		report.add("missing_from_source", false, err.Error())
+	} else if exists == 0 {
+		report.add("missing_from_source", true, "app_users does not exist on source")
+	} else if err := source.QueryRowContext(ctx, "SELECT COUNT(*) FROM app_users WHERE name = @p1", report.Tag).Scan(&onSource); err != nil {
+		report.add("missing_from_source", false, err.Error())
+	} else {
+		report.add("missing_from_source", onSource == 0, fmt.Sprintf("%d row(s) on source", onSource))
+	}
+
+	if os.Getenv("VERIFY_KEEP_ROW") != "true" {
+		if _, err := branch.Exec("DELETE FROM app_users WHERE id = @p1", id); err != nil {
+			log.Printf("Warning: failed to remove tagged row %d: %v", id, err)
+		}
+	}
+
+	return finishReport(report, start)
+}
+
+// openSource connects to SOURCE_DATABASE_URL and pins a single connection for
+// every source query. SQL Server has no read-only session switch, so the
+// check only ever issues SELECTs against it.
+func openSource(ctx context.Context) (*sql.DB, *sql.Conn, error) {
+	sourceURL := os.Getenv("SOURCE_DATABASE_URL")
+	if sourceURL == "" {
+		return nil, nil, fmt.Errorf("SOURCE_DATABASE_URL is not set")
+	}
+	log.Printf("Connecting to source database: %s", maskPassword(sourceURL))
+
+	connStr, err := toSQLServerURL(sourceURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("SOURCE_DATABASE_URL: %w", err)
+	}
+	db, err := sql.Open("sqlserver", connStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, conn, nil
+}
+
+func mssqlIdentity(ctx context.Context, q querier) map[string]string {
+	var database, serverName, version string
+	var addr sql.NullString
+	var port sql.NullInt64
+	var spid int
+	err := q.QueryRowContext(ctx, `
+		SELECT DB_NAME(), @@SERVERNAME,
+		       CAST(CONNECTIONPROPERTY('local_net_address') AS NVARCHAR(48)),
+		       CAST(CONNECTIONPROPERTY('local_tcp_port') AS INT),
+		       @@SPID, CAST(SERVERPROPERTY('ProductVersion') AS NVARCHAR(128))
+	`).Scan(&database, &serverName, &addr, &port, &spid, &version)
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	server := serverName
+	// local_net_address is NULL over shared memory.
+	if addr.Valid {
+		server = fmt.Sprintf("%s:%d", addr.String, port.Int64)
+	}
+	return map[string]string{
+		"database":    database,
+		"server":      server,
+		"server_name": serverName,
+		"spid":        strconv.Itoa(spid),
+		"version":     version,
+	}
+}
+
+func compareCounts(ctx context.Context, report *isolationReport, source, branch querier) {
+	switch report.CountMode {
+	case "report", "match", "empty":
+	default:
+		report.add("row_counts", false, fmt.Sprintf("unknown VERIFY_COUNTS %q (want report, match or empty)", report.CountMode))
+		return
+	}
+
+	expected, err := parseExpectedCounts(os.Getenv("VERIFY_EXPECT_COUNTS"))
+	if err != nil {
+		report.add("row_counts", false, err.Error())
+		return
+	}
+
+	sourceCounts, err := tableCounts(ctx, source)
+	if err != nil {
+		report.add("row_counts", false, "source: "+err.Error())
+		return
+	}
+	branchCounts, err := tableCounts(ctx, branch)
+	if err != nil {
+		report.add("row_counts", false, "branch: "+err.Error())
+		return
+	}
+
+	failed := 0
+	for _, name := range sortedKeys(sourceCounts, branchCounts) {
+		tc := tableCount{Table: name, Passed: true}
+		if n, ok := sourceCounts[name]; ok {
+			tc.Source = &n
+		}
+		if n, ok := branchCounts[name]; ok {
+			tc.Branch = &n
+		}
+		branchRows := int64(0)
+		if tc.Branch != nil {
+			branchRows = *tc.Branch
+		}
+
+		if want, ok := lookupExpected(expected, name); ok {
+			tc.Expected = &want
+			tc.Passed = tc.Branch != nil && *tc.Branch == want
+		} else if tc.Source != nil {
+			switch report.CountMode {
+			case "match":
+				tc.Passed = tc.Branch != nil && *tc.Branch == *tc.Source
+			case "empty":
+				tc.Passed = branchRows == 0
+			}
+		}
+		if !tc.Passed {
+			failed++
+		}
+		report.Tables = append(report.Tables, tc)
+	}
+	report.add("row_counts", failed == 0, fmt.Sprintf("mode=%s, %d table(s), %d mismatched", report.CountMode, len(report.Tables), failed))
+}
+
+func tableCounts(ctx context.Context, q querier) (map[string]int64, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT s.name, t.name
+		FROM sys.tables t
+		JOIN sys.schemas s ON s.schema_id = t.schema_id
+		WHERE t.is_ms_shipped = 0
+		ORDER BY s.name, t.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	type table struct{ schema, name string }
+	var tables []table
+	for rows.Next() {
+		var t table
+		if err := rows.Scan(&t.schema, &t.name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		var n int64
+		if err := q.QueryRowContext(ctx, "SELECT COUNT_BIG(*) FROM "+quoteName(t.schema)+"."+quoteName(t.name)).Scan(&n); err != nil {
+			return nil, fmt.Errorf("count %s.%s: %w", t.schema, t.name, err)
+		}
+		counts[t.schema+"."+t.name] = n
+	}
+	return counts, nil
+}
+
+func quoteName(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+func parseExpectedCounts(spec string) (map[string]int64, error) {
+	expected := map[string]int64{}
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("VERIFY_EXPECT_COUNTS entry %q must be table=count", kv)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("VERIFY_EXPECT_COUNTS entry %q: %w", kv, err)
+		}
+		expected[strings.TrimSpace(parts[0])] = n
+	}
+	return expected, nil
+}
+
+// lookupExpected matches either the qualified name (dbo.users) or the bare
+// table name (users).
+func lookupExpected(expected map[string]int64, table string) (int64, bool) {
+	if n, ok := expected[table]; ok {
+		return n, true
+	}
+	n, ok := expected[table[strings.LastIndex(table, ".")+1:]]
+	return n, ok
+}
+
+func sortedKeys(a, b map[string]int64) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]int64{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func finishReport(report *isolationReport, start time.Time) int {
+	report.Passed = true
+	for _, c := range report.Checks {
+		if !c.Passed {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Isolation check FAILED")
+		return 1
+	}
+	log.Println("Isolation check passed")
+	return 0
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	golog "github.com/siddontang/go-log/log"
+)
+
+// The binlog tail connects to SOURCE_DATABASE_URL as a replica, starting at
+// the source's current binlog position, and looks for the check's tag in
+// every row and query event that follows. A branch that is really isolated
+// never lets one through. FIDELITY_BINLOG_WAIT (default 5s) is how long the
+// tail keeps reading after the branch writes. The source needs log_bin=ON and
+// a user with REPLICATION SLAVE; MariaDB leaves the binlog off by default, in
+// which case the tail is reported as skipped.
+
+type binlogResult struct {
+	Source    string   `json:"source,omitempty"`
+	StartFile string   `json:"start_file,omitempty"`
+	StartPos  uint32   `json:"start_pos,omitempty"`
+	Events    int      `json:"events"`
+	Leaked    []string `json:"leaked,omitempty"`
+	Skipped   string   `json:"skipped,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Passed    bool     `json:"passed"`
+}
+
+type binlogTail struct {
+	tag    string
+	syncer *replication.BinlogSyncer
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	result *binlogResult
+}
+
+func startBinlogTail(ctx context.Context, tag string) (*binlogTail, error) {
+	cfg, err := mysqlConfig(getEnvDefault("SOURCE_DATABASE_URL", ""))
+	if err != nil {
+		return nil, fmt.Errorf("SOURCE_DATABASE_URL: %w", err)
+	}
+	if cfg.Net != "tcp" {
+		return nil, fmt.Errorf("binlog tail needs a TCP source, not %s", cfg.Net)
+	}
+	host, portStr, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	result := &binlogResult{Source: cfg.Addr}
+	tail := &binlogTail{tag: tag, result: result, done: make(chan struct{})}
+
+	var name, logBin, version string
+	if err := db.QueryRowContext(ctx, "SHOW VARIABLES LIKE 'log_bin'").Scan(&name, &logBin); err != nil {
+		return nil, fmt.Errorf("read log_bin: %w", err)
+	}
+	if !strings.EqualFold(logBin, "ON") && logBin != "1" {
+		result.Skipped = "log_bin is OFF on the source"
+		result.Passed = true
+		log.Printf("Binlog tail skipped: %s", result.Skipped)
+		close(tail.done)
+		return tail, nil
+	}
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return nil, err
+	}
+	file, pos, err := binlogPosition(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	result.StartFile, result.StartPos = file, pos
+
+	tail.syncer = replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID: uint32(100000 + rand.Intn(900000)),
+		Flavor:   flavorOf(version),
+		Host:     host,
+		Port:     uint16(port),
+		User:     cfg.User,
+		Password: cfg.Passwd,
+		Logger:   golog.NewDefault(&golog.NullHandler{}),
+	})
+	streamer, err := tail.syncer.StartSync(gomysql.Position{Name: file, Pos: pos})
+	if err != nil {
+		tail.syncer.Close()
+		return nil, fmt.Errorf("start binlog sync: %w", err)
+	}
+	log.Printf("Tailing source binlog from %s:%d", file, pos)
+
+	tailCtx, cancel := context.WithCancel(context.Background())
+	tail.cancel = cancel
+	go tail.read(tailCtx, streamer)
+	return tail, nil
+}
+
+// binlogPosition reads File and Position from SHOW MASTER STATUS, or SHOW
+// BINARY LOG STATUS on MySQL 8.4 where the former is gone.
+func binlogPosition(ctx context.Context, db *sql.DB) (string, uint32, error) {
+	var lastErr error
+	for _, stmt := range []string{"SHOW MASTER STATUS", "SHOW BINARY LOG STATUS"} {
+		rows, err := db.QueryContext(ctx, stmt)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			return "", 0, err
+		}
+		if !rows.Next() {
+			return "", 0, fmt.Errorf("%s returned no rows", stmt)
+		}
+		values := make([]sql.RawBytes, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return "", 0, err
+		}
+		pos, err := strconv.ParseUint(string(values[1]), 10, 32)
+		if err != nil {
+			return "", 0, fmt.Errorf("binlog position %q: %w", values[1], err)
+		}
+		return string(values[0]), uint32(pos), nil
+	}
+	return "", 0, fmt.Errorf("read binlog position: %w", lastErr)
+}
+
+func (t *binlogTail) read(ctx context.Context, streamer *replication.BinlogStreamer) {
+	defer close(t.done)
+	tag := []byte(t.tag)
+	for {
+		ev, err := streamer.GetEvent(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				t.mu.Lock()
+				t.result.Error = err.Error()
+				t.mu.Unlock()
+			}
+			return
+		}
+
+		var leaked string
+		switch e := ev.Event.(type) {
+		case *replication.RowsEvent:
+			for _, row := range e.Rows {
+				for _, v := range row {
+					if s, ok := v.(string); ok && strings.Contains(s, t.tag) {
+						leaked = fmt.Sprintf("%s %s.%s", ev.Header.EventType, e.Table.Schema, e.Table.Table)
+					}
+					if b, ok := v.([]byte); ok && bytes.Contains(b, tag) {
+						leaked = fmt.Sprintf("%s %s.%s", ev.Header.EventType, e.Table.Schema, e.Table.Table)
+					}
+				}
+			}
+		case *replication.QueryEvent:
+			if bytes.Contains(e.Query, tag) {
+				leaked = fmt.Sprintf("%s %s", ev.Header.EventType, e.Query)
+			}
+		}
+
+		t.mu.Lock()
+		t.result.Events++
+		if leaked != "" {
+			log.Printf("Branch write found in source binlog: %s", leaked)
+			t.result.Leaked = append(t.result.Leaked, leaked)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// finish keeps reading for FIDELITY_BINLOG_WAIT so late events still count,
+// then stops the tail and returns the result.
+func (t *binlogTail) finish() *binlogResult {
+	if t.syncer != nil {
+		wait, err := time.ParseDuration(getEnvDefault("FIDELITY_BINLOG_WAIT", "5s"))
+		if err != nil {
+			wait = 5 * time.Second
+		}
+		time.Sleep(wait)
+		t.cancel()
+		<-t.done
+		t.syncer.Close()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.result.Skipped == "" {
+		t.result.Passed = t.result.Error == "" && len(t.result.Leaked) == 0
+	}
+	return t.result
+}
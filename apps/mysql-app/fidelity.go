@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FIDELITY=setup creates a fixed set of server-side objects in the database it
+// is pointed at (normally the source, through a port-forward): generated
+// columns, a view, triggers, a stored procedure, a stored function and an
+// event. FIDELITY=check then runs against a branch and reports, per object,
+// whether the branch has it, whether its definition matches the source
+// (SOURCE_DATABASE_URL, optional) and whether it actually works: the view is
+// queried, the triggers fire, the procedure and function are called and the
+// generated columns are computed. Works on MySQL 8 and MariaDB 10.5+; the
+// report shows the server flavor so runs against both can be compared.
+//
+// FIDELITY_BINLOG=1 additionally tails the source binlog (see binlog.go) while
+// the check writes to the branch, and fails if any of those writes shows up.
+
+const fidelityTable = "fidelity_items"
+
+// fidelitySetup is executed statement by statement; the driver sends each
+// one whole, so compound bodies need no DELIMITER.
+var fidelitySetup = []string{
+	"DROP EVENT IF EXISTS fidelity_purge",
+	"DROP VIEW IF EXISTS fidelity_item_totals",
+	"DROP PROCEDURE IF EXISTS fidelity_add_item",
+	"DROP FUNCTION IF EXISTS fidelity_line_total",
+	"DROP TABLE IF EXISTS fidelity_audit",
+	"DROP TABLE IF EXISTS fidelity_items",
+	`CREATE TABLE fidelity_items (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		quantity INT NOT NULL,
+		unit_price_cents INT NOT NULL,
+		total_cents INT AS (quantity * unit_price_cents) STORED,
+		name_upper VARCHAR(100) AS (UPPER(name)) VIRTUAL,
+		update_count INT NOT NULL DEFAULT 0
+	) ENGINE=InnoDB`,
+	`CREATE TABLE fidelity_audit (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		item_id INT NOT NULL,
+		action VARCHAR(10) NOT NULL,
+		logged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	) ENGINE=InnoDB`,
+	`CREATE VIEW fidelity_item_totals AS
+		SELECT name, SUM(total_cents) AS total_cents, COUNT(*) AS items
+		FROM fidelity_items GROUP BY name`,
+	`CREATE TRIGGER fidelity_items_after_insert AFTER INSERT ON fidelity_items
+		FOR EACH ROW INSERT INTO fidelity_audit (item_id, action) VALUES (NEW.id, 'insert')`,
+	`CREATE TRIGGER fidelity_items_before_update BEFORE UPDATE ON fidelity_items
+		FOR EACH ROW SET NEW.update_count = OLD.update_count + 1`,
+	`CREATE FUNCTION fidelity_line_total(quantity INT, unit_price_cents INT) RETURNS INT
+		DETERMINISTIC NO SQL
+		RETURN quantity * unit_price_cents`,
+	`CREATE PROCEDURE fidelity_add_item(IN item_name VARCHAR(100), IN qty INT, IN price INT, OUT new_id INT)
+	BEGIN
+		INSERT INTO fidelity_items (name, quantity, unit_price_cents) VALUES (item_name, qty, price);
+		SET new_id = LAST_INSERT_ID();
+	END`,
+	// Created disabled: it only has to survive the copy, never to run.
+	`CREATE EVENT fidelity_purge ON SCHEDULE EVERY 1 DAY DISABLE
+		DO DELETE FROM fidelity_audit WHERE logged_at < NOW() - INTERVAL 30 DAY`,
+	`INSERT INTO fidelity_items (name, quantity, unit_price_cents) VALUES
+		('widget', 2, 250), ('gadget', 1, 1999), ('widget', 5, 250)`,
+}
+
+// fidelityObjects maps kind/name to the query that returns its definition.
+// The current database name is stripped from definitions, since a branch
+// database is usually named differently from the source.
+var fidelityObjects = []struct {
+	kind, name, query string
+}{
+	{"generated_column", "fidelity_items.total_cents",
+		`SELECT CONCAT(column_type, ' ', extra, ' ', generation_expression) FROM information_schema.columns
+		 WHERE table_schema = DATABASE() AND table_name = 'fidelity_items' AND column_name = 'total_cents'`},
+	{"generated_column", "fidelity_items.name_upper",
+		`SELECT CONCAT(column_type, ' ', extra, ' ', generation_expression) FROM information_schema.columns
+		 WHERE table_schema = DATABASE() AND table_name = 'fidelity_items' AND column_name = 'name_upper'`},
+	{"view", "fidelity_item_totals",
+		`SELECT view_definition FROM information_schema.views
+		 WHERE table_schema = DATABASE() AND table_name = 'fidelity_item_totals'`},
+	{"trigger", "fidelity_items_after_insert",
+		`SELECT CONCAT(action_timing, ' ', event_manipulation, ' ', action_statement) FROM information_schema.triggers
+		 WHERE trigger_schema = DATABASE() AND trigger_name = 'fidelity_items_after_insert'`},
+	{"trigger", "fidelity_items_before_update",
+		`SELECT CONCAT(action_timing, ' ', event_manipulation, ' ', action_statement) FROM information_schema.triggers
+		 WHERE trigger_schema = DATABASE() AND trigger_name = 'fidelity_items_before_update'`},
+	{"function", "fidelity_line_total",
+		`SELECT CONCAT(is_deterministic, ' ', routine_definition) FROM information_schema.routines
+		 WHERE routine_schema = DATABASE() AND routine_type = 'FUNCTION' AND routine_name = 'fidelity_line_total'`},
+	{"procedure", "fidelity_add_item",
+		`SELECT routine_definition FROM information_schema.routines
+		 WHERE routine_schema = DATABASE() AND routine_type = 'PROCEDURE' AND routine_name = 'fidelity_add_item'`},
+	{"event", "fidelity_purge",
+		`SELECT CONCAT(interval_value, ' ', interval_field, ' ', status, ' ', event_definition) FROM information_schema.events
+		 WHERE event_schema = DATABASE() AND event_name = 'fidelity_purge'`},
+}
+
+type fidelityObject struct {
+	Kind           string `json:"kind"`
+	Name           string `json:"name"`
+	Exists         bool   `json:"exists"`
+	SameDefinition *bool  `json:"same_definition,omitempty"`
+	Works          bool   `json:"works"`
+	Detail         string `json:"detail,omitempty"`
+}
+
+type fidelityReport struct {
+	Engine     string            `json:"engine"`
+	Flavor     string            `json:"flavor"`
+	Passed     bool              `json:"passed"`
+	Tag        string            `json:"tag"`
+	Branch     map[string]string `json:"branch"`
+	Source     map[string]string `json:"source,omitempty"`
+	Objects    []fidelityObject  `json:"objects"`
+	Binlog     *binlogResult     `json:"binlog,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+func runFidelity(db *sql.DB, mode string) int {
+	switch mode {
+	case "setup":
+		for _, stmt := range fidelitySetup {
+			if _, err := db.Exec(stmt); err != nil {
+				log.Printf("Fidelity setup failed on %q: %v", firstLine(stmt), err)
+				return 1
+			}
+		}
+		log.Printf("Created fidelity objects (%d statements)", len(fidelitySetup))
+		return 0
+	case "1", "true", "check":
+		return runFidelityCheck(db)
+	default:
+		log.Printf("Unknown FIDELITY=%s (setup or check)", mode)
+		return 1
+	}
+}
+
+func runFidelityCheck(db *sql.DB) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	report := &fidelityReport{Engine: "mysql", Tag: fmt.Sprintf("fidelity-%d", time.Now().UnixNano())}
+	report.Branch = mysqlIdentity(ctx, db)
+	report.Flavor = flavorOf(report.Branch["version"])
+
+	var sourceDefs map[string]string
+	if os.Getenv("SOURCE_DATABASE_URL") != "" {
+		sourceDB, source, err := openSource(ctx)
+		if err != nil {
+			report.Error = "source: " + err.Error()
+			return finishFidelityReport(report, start)
+		}
+		report.Source = mysqlIdentity(ctx, source)
+		sourceDefs = objectDefinitions(ctx, source, report.Source["database"])
+		source.Close()
+		sourceDB.Close()
+	}
+
+	// The tail has to be positioned before the first branch write.
+	var tail *binlogTail
+	if os.Getenv("FIDELITY_BINLOG") == "1" {
+		var err error
+		if tail, err = startBinlogTail(ctx, report.Tag); err != nil {
+			report.Binlog = &binlogResult{Error: err.Error()}
+		}
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		return finishFidelityReport(report, start)
+	}
+	defer conn.Close()
+
+	branchDefs := objectDefinitions(ctx, conn, report.Branch["database"])
+	works := exerciseObjects(ctx, conn, report.Tag)
+	for _, o := range fidelityObjects {
+		key := o.kind + "/" + o.name
+		obj := fidelityObject{Kind: o.kind, Name: o.name}
+		def, exists := branchDefs[key]
+		obj.Exists = exists
+		if sourceDefs != nil {
+			same := exists && def == sourceDefs[key]
+			obj.SameDefinition = &same
+			if exists && !same {
+				obj.Detail = fmt.Sprintf("branch %q source %q", def, sourceDefs[key])
+			}
+		}
+		if err, ran := works[key]; ran {
+			obj.Works = err == nil
+			if err != nil {
+				obj.Detail = strings.TrimSpace(obj.Detail + " " + err.Error())
+			}
+		}
+		report.Objects = append(report.Objects, obj)
+	}
+
+	if tail != nil {
+		report.Binlog = tail.finish()
+	}
+	return finishFidelityReport(report, start)
+}
+
+func objectDefinitions(ctx context.Context, q querier, database string) map[string]string {
+	defs := map[string]string{}
+	for _, o := range fidelityObjects {
+		var def sql.NullString
+		if err := q.QueryRowContext(ctx, o.query).Scan(&def); err != nil {
+			continue
+		}
+		d := def.String
+		if database != "" {
+			d = strings.ReplaceAll(d, "`"+database+"`.", "")
+		}
+		defs[o.kind+"/"+o.name] = strings.Join(strings.Fields(d), " ")
+	}
+	return defs
+}
+
+// exerciseObjects writes tagged rows through the objects on the branch and
+// returns a result per kind/name. Rows are committed on purpose: only
+// committed writes reach a binlog.
+func exerciseObjects(ctx context.Context, conn *sql.Conn, tag string) map[string]error {
+	results := map[string]error{}
+
+	var id, total int
+	var upper string
+	err := func() error {
+		res, err := conn.ExecContext(ctx, "INSERT INTO fidelity_items (name, quantity, unit_price_cents) VALUES (?, 3, 125)", tag)
+		if err != nil {
+			return err
+		}
+		last, _ := res.LastInsertId()
+		id = int(last)
+		return conn.QueryRowContext(ctx, "SELECT total_cents, name_upper FROM fidelity_items WHERE id = ?", id).Scan(&total, &upper)
+	}()
+	results["generated_column/fidelity_items.total_cents"] = expect(err, total == 375, "total_cents = %d, want 375", total)
+	results["generated_column/fidelity_items.name_upper"] = expect(err, upper == strings.ToUpper(tag), "name_upper = %q", upper)
+
+	var audits int
+	err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM fidelity_audit WHERE item_id = ? AND action = 'insert'", id).Scan(&audits)
+	results["trigger/fidelity_items_after_insert"] = expect(err, audits == 1, "%d audit rows, want 1", audits)
+
+	var updates int
+	_, err = conn.ExecContext(ctx, "UPDATE fidelity_items SET quantity = quantity + 1 WHERE id = ?", id)
+	if err == nil {
+		err = conn.QueryRowContext(ctx, "SELECT update_count FROM fidelity_items WHERE id = ?", id).Scan(&updates)
+	}
+	results["trigger/fidelity_items_before_update"] = expect(err, updates == 1, "update_count = %d, want 1", updates)
+
+	var lineTotal int
+	err = conn.QueryRowContext(ctx, "SELECT fidelity_line_total(4, 25)").Scan(&lineTotal)
+	results["function/fidelity_line_total"] = expect(err, lineTotal == 100, "fidelity_line_total(4, 25) = %d", lineTotal)
+
+	var newID sql.NullInt64
+	_, err = conn.ExecContext(ctx, "CALL fidelity_add_item(?, 1, 10, @fidelity_new_id)", tag+"-proc")
+	if err == nil {
+		err = conn.QueryRowContext(ctx, "SELECT @fidelity_new_id").Scan(&newID)
+	}
+	results["procedure/fidelity_add_item"] = expect(err, newID.Valid && newID.Int64 > 0, "OUT parameter not set")
+
+	var viewItems int
+	err = conn.QueryRowContext(ctx, "SELECT items FROM fidelity_item_totals WHERE name = ?", tag).Scan(&viewItems)
+	results["view/fidelity_item_totals"] = expect(err, viewItems == 1, "view counts %d rows for the tag, want 1", viewItems)
+
+	// An event is not run; it works if the branch still knows when it would.
+	var status string
+	err = conn.QueryRowContext(ctx, "SELECT status FROM information_schema.events WHERE event_schema = DATABASE() AND event_name = 'fidelity_purge'").Scan(&status)
+	results["event/fidelity_purge"] = expect(err, status != "", "no status")
+	return results
+}
+
+func expect(err error, ok bool, format string, args ...any) error {
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf(format, args...)
+	}
+	return nil
+}
+
+func flavorOf(version string) string {
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return "mariadb"
+	}
+	return "mysql"
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+func finishFidelityReport(report *fidelityReport, start time.Time) int {
+	sort.SliceStable(report.Objects, func(i, j int) bool { return report.Objects[i].Kind < report.Objects[j].Kind })
+	report.Passed = report.Error == "" && len(report.Objects) > 0
+	for _, o := range report.Objects {
+		if !o.Exists || !o.Works || (o.SameDefinition != nil && !*o.SameDefinition) {
+			report.Passed = false
+		}
+	}
+	if report.Binlog != nil && !report.Binlog.Passed {
+		report.Passed = false
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Fidelity check FAILED")
+		return 1
+	}
+	log.Println("Fidelity check passed")
+	return 0
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MIGRATE=up|down|status runs the SQL files in migrations/ against
+// MYSQL_CONNECTION_URL and exits. Files are named <version>_<name>.up.sql and
+// <version>_<name>.down.sql and applied in version order; applied versions are
+// recorded in schema_migrations. MIGRATIONS_DIR replaces the embedded set and
+// MIGRATE_STEPS sets how many versions `down` rolls back (default 1).
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.version, m.name)
+}
+
+func loadMigrations() ([]migration, error) {
+	var fsys fs.FS
+	if dir := os.Getenv("MIGRATIONS_DIR"); dir != "" {
+		fsys = os.DirFS(dir)
+	} else {
+		sub, err := fs.Sub(embeddedMigrations, "migrations")
+		if err != nil {
+			return nil, err
+		}
+		fsys = sub
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	byVersion := map[int64]*migration{}
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		} else if m.name != match[2] {
+			return nil, fmt.Errorf("version %d is used by both %s and %s", version, m.name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %s has no up file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+func runMigrations(db *sql.DB, direction string) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	switch direction {
+	case "up":
+		return migrateUp(db, migrations, applied)
+	case "down":
+		steps, err := strconv.Atoi(getEnvDefault("MIGRATE_STEPS", "1"))
+		if err != nil || steps < 1 {
+			return fmt.Errorf("MIGRATE_STEPS must be a positive integer")
+		}
+		return migrateDown(db, migrations, applied, steps)
+	case "status":
+		for _, m := range migrations {
+			state := "pending"
+			if at, ok := applied[m.version]; ok {
+				state = "applied " + at.Format(time.RFC3339)
+			}
+			log.Printf("  %s: %s", m, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown MIGRATE %q (want up, down or status)", direction)
+	}
+}
+
+func appliedVersions(db *sql.DB) (map[int64]time.Time, error) {
+	// applied_at is read as text so the DSN does not need parseTime=true.
+	rows, err := db.Query("SELECT version, DATE_FORMAT(applied_at, '%Y-%m-%dT%H:%i:%sZ') FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var raw string
+		if err := rows.Scan(&version, &raw); err != nil {
+			return nil, err
+		}
+		at, _ := time.Parse(time.RFC3339, raw)
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// migrateUp applies every pending migration. MySQL commits DDL implicitly, so
+// unlike Postgres a failing file can leave part of its statements applied; the
+// version is only recorded once the whole file succeeded.
+func migrateUp(db *sql.DB, migrations []migration, applied map[int64]time.Time) error {
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		pending++
+		start := time.Now()
+		if _, err := db.Exec(m.up); err != nil {
+			return fmt.Errorf("apply %s: %w", m, err)
+		}
+		if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+			return fmt.Errorf("record %s: %w", m, err)
+		}
+		log.Printf("Applied %s (%s)", m, time.Since(start).Round(time.Millisecond))
+	}
+	if pending == 0 {
+		log.Println("No pending migrations")
+	}
+	return nil
+}
+
+func migrateDown(db *sql.DB, migrations []migration, applied map[int64]time.Time, steps int) error {
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		if m.down == "" {
+			return fmt.Errorf("migration %s has no down file", m)
+		}
+		start := time.Now()
+		if _, err := db.Exec(m.down); err != nil {
+			return fmt.Errorf("roll back %s: %w", m, err)
+		}
+		if _, err := db.Exec("DELETE FROM schema_migrations WHERE version = ?", m.version); err != nil {
+			return fmt.Errorf("unrecord %s: %w", m, err)
+		}
+		log.Printf("Rolled back %s (%s)", m, time.Since(start).Round(time.Millisecond))
+		steps--
+	}
+	return nil
+}
+
+func getEnvDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,25 @@
+CREATE TABLE categories (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    parent_id INT NULL,
+    slug VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    CONSTRAINT categories_slug_key UNIQUE (slug),
+    CONSTRAINT categories_parent_fk FOREIGN KEY (parent_id) REFERENCES categories (id) ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+CREATE TABLE catalog_products (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    sku VARCHAR(32) NOT NULL,
+    category_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    status ENUM('draft', 'active', 'discontinued') NOT NULL DEFAULT 'draft',
+    price_cents INT UNSIGNED NOT NULL,
+    attributes JSON NULL,
+    description TEXT NULL,
+    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    CONSTRAINT catalog_products_sku_key UNIQUE (sku),
+    CONSTRAINT catalog_products_category_fk FOREIGN KEY (category_id) REFERENCES categories (id),
+    CONSTRAINT catalog_products_price_check CHECK (price_cents < 100000000),
+    INDEX catalog_products_category_idx (category_id, status),
+    FULLTEXT INDEX catalog_products_search_idx (name, description)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
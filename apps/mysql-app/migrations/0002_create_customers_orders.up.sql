@@ -0,0 +1,32 @@
+CREATE TABLE customers (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    full_name VARCHAR(255) NOT NULL,
+    country CHAR(2) NOT NULL DEFAULT 'US',
+    tags SET('vip', 'wholesale', 'newsletter') NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT customers_email_key UNIQUE (email)
+) ENGINE=InnoDB;
+
+CREATE TABLE customer_orders (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    customer_id BIGINT NOT NULL,
+    status ENUM('pending', 'paid', 'shipped', 'cancelled') NOT NULL DEFAULT 'pending',
+    placed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    shipped_at DATETIME NULL,
+    CONSTRAINT customer_orders_customer_fk FOREIGN KEY (customer_id) REFERENCES customers (id) ON DELETE RESTRICT,
+    CONSTRAINT customer_orders_shipped_after_placed CHECK (shipped_at IS NULL OR shipped_at >= placed_at),
+    INDEX customer_orders_customer_idx (customer_id, placed_at DESC)
+) ENGINE=InnoDB;
+
+CREATE TABLE order_items (
+    order_id BIGINT NOT NULL,
+    product_id BIGINT NOT NULL,
+    quantity INT NOT NULL,
+    unit_price_cents INT NOT NULL,
+    line_total_cents INT AS (quantity * unit_price_cents) STORED,
+    PRIMARY KEY (order_id, product_id),
+    CONSTRAINT order_items_order_fk FOREIGN KEY (order_id) REFERENCES customer_orders (id) ON DELETE CASCADE,
+    CONSTRAINT order_items_product_fk FOREIGN KEY (product_id) REFERENCES catalog_products (id),
+    CONSTRAINT order_items_quantity_check CHECK (quantity > 0)
+) ENGINE=InnoDB;
@@ -0,0 +1,11 @@
+CREATE TABLE invoices (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    number INT UNSIGNED NOT NULL,
+    order_id BIGINT NOT NULL,
+    amount_cents BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    issued_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT invoices_number_key UNIQUE (number),
+    CONSTRAINT invoices_order_fk FOREIGN KEY (order_id) REFERENCES customer_orders (id),
+    CONSTRAINT invoices_amount_positive CHECK (amount_cents > 0)
+) ENGINE=InnoDB AUTO_INCREMENT=50000;
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SCHEMA_DIFF=1 compares the schema of the branch (MYSQL_CONNECTION_URL) with the
+// source (SOURCE_DATABASE_URL) and prints a JSON report: tables, columns,
+// indexes, constraints, sequences and enums that exist on only one side or
+// are defined differently. It exits 0 when the schemas are identical.
+// SCHEMA_DIFF_IGNORE is a CSV of object names (e.g. app_users) to leave out,
+// together with everything that belongs to them.
+
+type schemaDiff struct {
+	OnlyInBranch []string        `json:"only_in_branch"`
+	OnlyInSource []string        `json:"only_in_source"`
+	Different    []objectChanged `json:"different"`
+	BranchCount  int             `json:"branch_count"`
+	SourceCount  int             `json:"source_count"`
+}
+
+type objectChanged struct {
+	Name   string `json:"name"`
+	Branch string `json:"branch"`
+	Source string `json:"source"`
+}
+
+type schemaDiffReport struct {
+	Engine     string                 `json:"engine"`
+	Identical  bool                   `json:"identical"`
+	Branch     map[string]string      `json:"branch"`
+	Source     map[string]string      `json:"source"`
+	Categories map[string]*schemaDiff `json:"categories"`
+	Skipped    map[string]string      `json:"skipped,omitempty"`
+}
+
+// schemaQueries return (name, definition) pairs per category for the current
+// database. MySQL has no enum or sequence objects, so enums lists ENUM and SET
+// columns and sequences only finds MariaDB sequences.
+var schemaQueries = map[string]string{
+	"tables": `
+		SELECT table_name, CONCAT_WS(' ', table_type, engine, table_collation)
+		FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type <> 'SEQUENCE'`,
+	"columns": `
+		SELECT CONCAT(table_name, '.', column_name),
+		       CONCAT_WS(' ', column_type,
+		           IF(is_nullable = 'NO', 'NOT NULL', NULL),
+		           CONCAT('DEFAULT ', column_default),
+		           NULLIF(extra, ''),
+		           IF(generation_expression <> '', CONCAT('AS ', generation_expression), NULL),
+		           collation_name)
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE()`,
+	"indexes": `
+		SELECT CONCAT(table_name, '.', index_name),
+		       CONCAT_WS(' ', IF(non_unique = 0, 'UNIQUE', NULL), index_type,
+		           GROUP_CONCAT(CONCAT(IFNULL(column_name, '<expression>'),
+		                               IFNULL(CONCAT('(', sub_part, ')'), ''),
+		                               IF(collation = 'D', ' DESC', ''))
+		                        ORDER BY seq_in_index SEPARATOR ','))
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE()
+		GROUP BY table_name, index_name, non_unique, index_type`,
+	"constraints": `
+		SELECT CONCAT(tc.table_name, '.', tc.constraint_name),
+		       CONCAT_WS(' ', tc.constraint_type,
+		           (SELECT GROUP_CONCAT(k.column_name ORDER BY k.ordinal_position)
+		            FROM information_schema.key_column_usage k
+		            WHERE k.constraint_schema = tc.constraint_schema
+		              AND k.table_name = tc.table_name
+		              AND k.constraint_name = tc.constraint_name),
+		           (SELECT CONCAT('REFERENCES ', r.referenced_table_name,
+		                          ' ON UPDATE ', r.update_rule, ' ON DELETE ', r.delete_rule)
+		            FROM information_schema.referential_constraints r
+		            WHERE r.constraint_schema = tc.constraint_schema
+		              AND r.table_name = tc.table_name
+		              AND r.constraint_name = tc.constraint_name))
+		FROM information_schema.table_constraints tc
+		WHERE tc.table_schema = DATABASE() AND tc.constraint_type <> 'CHECK'`,
+	"checks": `
+		SELECT CONCAT(tc.table_name, '.', cc.constraint_name), cc.check_clause
+		FROM information_schema.check_constraints cc
+		JOIN information_schema.table_constraints tc
+		  ON tc.constraint_schema = cc.constraint_schema
+		 AND tc.constraint_name = cc.constraint_name
+		 AND tc.constraint_type = 'CHECK'
+		WHERE cc.constraint_schema = DATABASE()`,
+	"sequences": `
+		SELECT table_name, 'SEQUENCE'
+		FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'SEQUENCE'`,
+	"enums": `
+		SELECT CONCAT(table_name, '.', column_name), column_type
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND data_type IN ('enum', 'set')`,
+}
+
+// optionalCategories are skipped instead of failing the run when the server
+// is too old to answer them (check_constraints needs MySQL 8.0.16).
+var optionalCategories = map[string]bool{"checks": true}
+
+func isSchemaDiffMode() bool {
+	v := os.Getenv("SCHEMA_DIFF")
+	return v == "1" || v == "true"
+}
+
+func runSchemaDiff(branch querier) int {
+	ctx := context.Background()
+	sourceDB, source, err := openSource(ctx)
+	if err != nil {
+		log.Printf("Failed to connect to source: %v", err)
+		return 1
+	}
+	defer sourceDB.Close()
+	defer source.Close()
+
+	report := &schemaDiffReport{
+		Engine:     "mysql",
+		Identical:  true,
+		Branch:     mysqlIdentity(ctx, branch),
+		Source:     mysqlIdentity(ctx, source),
+		Categories: map[string]*schemaDiff{},
+	}
+	ignore := splitCSV(os.Getenv("SCHEMA_DIFF_IGNORE"))
+
+	for category, query := range schemaQueries {
+		branchObjects, err := schemaObjects(ctx, branch, query, ignore)
+		if err != nil && optionalCategories[category] {
+			if report.Skipped == nil {
+				report.Skipped = map[string]string{}
+			}
+			report.Skipped[category] = err.Error()
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to read %s from branch: %v", category, err)
+			return 1
+		}
+		sourceObjects, err := schemaObjects(ctx, source, query, ignore)
+		if err != nil {
+			log.Printf("Failed to read %s from source: %v", category, err)
+			return 1
+		}
+		diff := diffObjects(branchObjects, sourceObjects)
+		if len(diff.OnlyInBranch)+len(diff.OnlyInSource)+len(diff.Different) > 0 {
+			report.Identical = false
+		}
+		report.Categories[category] = diff
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Identical {
+		log.Println("Schemas differ")
+		return 1
+	}
+	log.Println("Schemas are identical")
+	return 0
+}
+
+func schemaObjects(ctx context.Context, q querier, query string, ignore []string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := map[string]string{}
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			return nil, err
+		}
+		if !ignored(name, ignore) {
+			objects[name] = definition
+		}
+	}
+	return objects, rows.Err()
+}
+
+func diffObjects(branch, source map[string]string) *schemaDiff {
+	diff := &schemaDiff{
+		OnlyInBranch: []string{},
+		OnlyInSource: []string{},
+		Different:    []objectChanged{},
+		BranchCount:  len(branch),
+		SourceCount:  len(source),
+	}
+	for name, def := range branch {
+		srcDef, ok := source[name]
+		switch {
+		case !ok:
+			diff.OnlyInBranch = append(diff.OnlyInBranch, name)
+		case srcDef != def:
+			diff.Different = append(diff.Different, objectChanged{Name: name, Branch: def, Source: srcDef})
+		}
+	}
+	for name := range source {
+		if _, ok := branch[name]; !ok {
+			diff.OnlyInSource = append(diff.OnlyInSource, name)
+		}
+	}
+	sort.Strings(diff.OnlyInBranch)
+	sort.Strings(diff.OnlyInSource)
+	sort.Slice(diff.Different, func(i, j int) bool { return diff.Different[i].Name < diff.Different[j].Name })
+	return diff
+}
+
+// ignored reports whether name is one of the ignored objects or belongs to
+// one (app_users also drops app_users.id and its indexes).
+func ignored(name string, ignore []string) bool {
+	for _, prefix := range ignore {
+		if name == prefix || strings.HasPrefix(name, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func splitCSV(csv string) []string {
+	var items []string
+	for _, s := range strings.Split(csv, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// STRESS=1 load-tests the database instead of starting the app. STRESS_WORKERS
+// goroutines run a read/write mix against a stress_events table for
+// STRESS_DURATION, each operation bounded by STRESS_OP_TIMEOUT so stalls show
+// up as errors. STRESS_HOLD_TX transactions are opened first and kept idle for
+// the whole run, then checked and rolled back, to catch branches that kill
+// long-lived sessions. The pool is configured from STRESS_MAX_OPEN,
+// STRESS_MAX_IDLE, STRESS_CONN_MAX_LIFETIME and STRESS_CONN_MAX_IDLE_TIME.
+//
+// The JSON report has per-operation p50/p90/p99 latencies, the error rate and
+// the pool stats. Running the same settings against the source gives the
+// baseline to compare a branch with. The process exits 1 when the error rate
+// is above STRESS_MAX_ERROR_RATE (default 0) or a held transaction was lost.
+// stress_events is dropped afterwards unless STRESS_KEEP_TABLE=1.
+
+const (
+	stressCreateTable = `CREATE TABLE IF NOT EXISTS stress_events (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		worker INT NOT NULL,
+		payload VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX stress_events_worker (worker, id)
+	)`
+	stressInsert = `INSERT INTO stress_events (worker, payload) VALUES (?, ?)`
+	stressSelect = `SELECT id, payload FROM stress_events WHERE worker = ? ORDER BY id DESC LIMIT 10`
+	stressCount  = `SELECT COUNT(*) FROM stress_events WHERE worker = ?`
+	stressDrop   = `DROP TABLE IF EXISTS stress_events`
+)
+
+type stressConfig struct {
+	Workers         int     `json:"workers"`
+	Duration        string  `json:"duration"`
+	WritePercent    int     `json:"write_percent"`
+	OpTimeout       string  `json:"op_timeout"`
+	HoldTx          int     `json:"hold_tx"`
+	MaxOpen         int     `json:"max_open"`
+	MaxIdle         int     `json:"max_idle"`
+	ConnMaxLifetime string  `json:"conn_max_lifetime"`
+	ConnMaxIdleTime string  `json:"conn_max_idle_time"`
+	MaxErrorRate    float64 `json:"max_error_rate"`
+}
+
+type opStats struct {
+	Count  int     `json:"count"`
+	Errors int     `json:"errors"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+type heldTx struct {
+	Index    int    `json:"index"`
+	Survived bool   `json:"survived"`
+	Error    string `json:"error,omitempty"`
+}
+
+type poolStats struct {
+	MaxOpen           int     `json:"max_open"`
+	Open              int     `json:"open"`
+	InUse             int     `json:"in_use"`
+	Idle              int     `json:"idle"`
+	WaitCount         int64   `json:"wait_count"`
+	WaitMs            float64 `json:"wait_ms"`
+	MaxIdleClosed     int64   `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64   `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64   `json:"max_lifetime_closed"`
+}
+
+type stressReport struct {
+	Engine     string             `json:"engine"`
+	Passed     bool               `json:"passed"`
+	Config     stressConfig       `json:"config"`
+	Operations map[string]opStats `json:"operations"`
+	TotalOps   int                `json:"total_ops"`
+	OpsPerSec  float64            `json:"ops_per_sec"`
+	ErrorRate  float64            `json:"error_rate"`
+	Errors     map[string]int     `json:"errors,omitempty"`
+	HeldTx     []heldTx           `json:"held_tx,omitempty"`
+	Pool       poolStats          `json:"pool"`
+	Error      string             `json:"error,omitempty"`
+	DurationMs int64              `json:"duration_ms"`
+}
+
+// stressRecorder collects latencies per operation plus a count of each
+// distinct error message.
+type stressRecorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	failed    map[string]int
+}
+
+func (r *stressRecorder) record(op string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[op] = append(r.latencies[op], d)
+	if err != nil {
+		r.failed[op]++
+		if len(r.errors) < 20 || r.errors[err.Error()] > 0 {
+			r.errors[err.Error()]++
+		}
+	}
+}
+
+func isStressMode() bool {
+	v := os.Getenv("STRESS")
+	return v == "1" || v == "true"
+}
+
+func runStress(db *sql.DB) int {
+	start := time.Now()
+	report := &stressReport{Engine: "mysql", Operations: map[string]opStats{}}
+
+	cfg, duration, opTimeout, err := loadStressConfig()
+	report.Config = cfg
+	if err != nil {
+		report.Error = err.Error()
+		return finishStressReport(report, start)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpen)
+	db.SetMaxIdleConns(cfg.MaxIdle)
+	if d, _ := time.ParseDuration(cfg.ConnMaxLifetime); d > 0 {
+		db.SetConnMaxLifetime(d)
+	}
+	if d, _ := time.ParseDuration(cfg.ConnMaxIdleTime); d > 0 {
+		db.SetConnMaxIdleTime(d)
+	}
+
+	if _, err := db.Exec(stressCreateTable); err != nil {
+		report.Error = "create stress_events: " + err.Error()
+		return finishStressReport(report, start)
+	}
+	if os.Getenv("STRESS_KEEP_TABLE") != "1" {
+		defer func() {
+			if _, err := db.Exec(stressDrop); err != nil {
+				log.Printf("Failed to drop stress_events: %v", err)
+			}
+		}()
+	}
+
+	// Held transactions run on their own context: database/sql rolls a
+	// transaction back as soon as the context it was begun with is done.
+	held := make([]*sql.Tx, cfg.HoldTx)
+	report.HeldTx = make([]heldTx, cfg.HoldTx)
+	for i := range held {
+		report.HeldTx[i].Index = i
+		tx, err := db.BeginTx(context.Background(), nil)
+		if err != nil {
+			report.HeldTx[i].Error = "begin: " + err.Error()
+			continue
+		}
+		if _, err := tx.Exec(stressInsert, -1-i, "held transaction"); err != nil {
+			// Release the connection, or it stays pinned for the whole run
+			// and skews the pool stats.
+			tx.Rollback()
+			report.HeldTx[i].Error = "insert: " + err.Error()
+			continue
+		}
+		held[i] = tx
+	}
+	if cfg.HoldTx > 0 {
+		log.Printf("Holding %d transactions open for the run", cfg.HoldTx)
+	}
+
+	log.Printf("Stress: %d workers for %s, %d%% writes, pool max open %d idle %d",
+		cfg.Workers, duration, cfg.WritePercent, cfg.MaxOpen, cfg.MaxIdle)
+	rec := &stressRecorder{latencies: map[string][]time.Duration{}, errors: map[string]int{}, failed: map[string]int{}}
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			stressWorker(ctx, db, rec, worker, cfg.WritePercent, opTimeout)
+		}(w)
+	}
+	go logStressProgress(ctx, db, rec)
+	wg.Wait()
+
+	for i, tx := range held {
+		if tx == nil {
+			continue
+		}
+		var n int
+		err := tx.QueryRow(stressCount, -1-i).Scan(&n)
+		if err == nil && n != 1 {
+			err = fmt.Errorf("held row count %d, want 1", n)
+		}
+		if err != nil {
+			report.HeldTx[i].Error = err.Error()
+		} else {
+			report.HeldTx[i].Survived = true
+		}
+		tx.Rollback()
+	}
+
+	report.Pool = currentPoolStats(db)
+	elapsed := time.Since(start)
+	for op, lat := range rec.latencies {
+		report.Operations[op] = summarize(lat, rec.failed[op])
+		report.TotalOps += len(lat)
+	}
+	failed := 0
+	for _, n := range rec.failed {
+		failed += n
+	}
+	if report.TotalOps > 0 {
+		report.ErrorRate = float64(failed) / float64(report.TotalOps)
+		report.OpsPerSec = float64(report.TotalOps) / duration.Seconds()
+	}
+	if len(rec.errors) > 0 {
+		report.Errors = rec.errors
+	}
+	log.Printf("Stress finished after %s: %d ops, error rate %.4f", elapsed.Round(time.Millisecond), report.TotalOps, report.ErrorRate)
+	return finishStressReport(report, start)
+}
+
+func stressWorker(ctx context.Context, db *sql.DB, rec *stressRecorder, worker, writePercent int, opTimeout time.Duration) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+	for seq := 0; ctx.Err() == nil; seq++ {
+		op := "read"
+		if rng.Intn(100) < writePercent {
+			op = "write"
+		}
+		opCtx, cancel := context.WithTimeout(ctx, opTimeout)
+		begin := time.Now()
+		var err error
+		if op == "write" {
+			_, err = db.ExecContext(opCtx, stressInsert, worker, fmt.Sprintf("worker %d op %d", worker, seq))
+		} else {
+			err = stressRead(opCtx, db, worker)
+		}
+		elapsed := time.Since(begin)
+		cancel()
+		// Operations cut short by the end of the run are not failures.
+		if ctx.Err() != nil {
+			return
+		}
+		rec.record(op, elapsed, err)
+		if err != nil {
+			// Back off briefly so a refused connection does not spin.
+			select {
+			case <-ctx.Done():
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+}
+
+func stressRead(ctx context.Context, db *sql.DB, worker int) error {
+	rows, err := db.QueryContext(ctx, stressSelect, worker)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func logStressProgress(ctx context.Context, db *sql.DB, rec *stressRecorder) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rec.mu.Lock()
+			ops, failed := 0, 0
+			for op, lat := range rec.latencies {
+				ops += len(lat)
+				failed += rec.failed[op]
+			}
+			rec.mu.Unlock()
+			s := db.Stats()
+			log.Printf("Stress progress: %d ops, %d errors, %d open / %d in use, %d waits",
+				ops, failed, s.OpenConnections, s.InUse, s.WaitCount)
+		}
+	}
+}
+
+func loadStressConfig() (stressConfig, time.Duration, time.Duration, error) {
+	cfg := stressConfig{
+		Duration:        getEnvDefault("STRESS_DURATION", "30s"),
+		OpTimeout:       getEnvDefault("STRESS_OP_TIMEOUT", "10s"),
+		ConnMaxLifetime: os.Getenv("STRESS_CONN_MAX_LIFETIME"),
+		ConnMaxIdleTime: os.Getenv("STRESS_CONN_MAX_IDLE_TIME"),
+	}
+	ints := []struct {
+		key      string
+		fallback int
+		dest     *int
+	}{
+		{"STRESS_WORKERS", 16, &cfg.Workers},
+		{"STRESS_WRITE_PERCENT", 20, &cfg.WritePercent},
+		{"STRESS_HOLD_TX", 0, &cfg.HoldTx},
+		{"STRESS_MAX_OPEN", 0, &cfg.MaxOpen},
+		{"STRESS_MAX_IDLE", 0, &cfg.MaxIdle},
+	}
+	for _, v := range ints {
+		n, err := strconv.Atoi(getEnvDefault(v.key, strconv.Itoa(v.fallback)))
+		if err != nil || n < 0 {
+			return cfg, 0, 0, fmt.Errorf("%s must be a non-negative integer", v.key)
+		}
+		*v.dest = n
+	}
+	if cfg.Workers == 0 {
+		return cfg, 0, 0, fmt.Errorf("STRESS_WORKERS must be at least 1")
+	}
+	// Held transactions each pin a connection, so the default pool leaves
+	// room for them on top of the workers.
+	if cfg.MaxOpen == 0 {
+		cfg.MaxOpen = cfg.Workers + cfg.HoldTx
+	}
+	if cfg.MaxIdle == 0 {
+		cfg.MaxIdle = cfg.MaxOpen
+	}
+	rate, err := strconv.ParseFloat(getEnvDefault("STRESS_MAX_ERROR_RATE", "0"), 64)
+	if err != nil {
+		return cfg, 0, 0, fmt.Errorf("STRESS_MAX_ERROR_RATE: %w", err)
+	}
+	cfg.MaxErrorRate = rate
+
+	duration, err := time.ParseDuration(cfg.Duration)
+	if err != nil {
+		return cfg, 0, 0, fmt.Errorf("STRESS_DURATION: %w", err)
+	}
+	opTimeout, err := time.ParseDuration(cfg.OpTimeout)
+	if err != nil {
+		return cfg, 0, 0, fmt.Errorf("STRESS_OP_TIMEOUT: %w", err)
+	}
+	for key, value := range map[string]string{"STRESS_CONN_MAX_LIFETIME": cfg.ConnMaxLifetime, "STRESS_CONN_MAX_IDLE_TIME": cfg.ConnMaxIdleTime} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return cfg, 0, 0, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return cfg, duration, opTimeout, nil
+}
+
+func summarize(lat []time.Duration, failed int) opStats {
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	ms := func(q float64) float64 {
+		if len(lat) == 0 {
+			return 0
+		}
+		return float64(lat[int(q*float64(len(lat)-1))].Microseconds()) / 1000
+	}
+	return opStats{Count: len(lat), Errors: failed, P50Ms: ms(0.50), P90Ms: ms(0.90), P99Ms: ms(0.99), MaxMs: ms(1)}
+}
+
+func currentPoolStats(db *sql.DB) poolStats {
+	s := db.Stats()
+	return poolStats{
+		MaxOpen:           s.MaxOpenConnections,
+		Open:              s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitMs:            float64(s.WaitDuration.Microseconds()) / 1000,
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxIdleTimeClosed: s.MaxIdleTimeClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}
+}
+
+func finishStressReport(report *stressReport, start time.Time) int {
+	report.Passed = report.Error == "" && report.TotalOps > 0 && report.ErrorRate <= report.Config.MaxErrorRate
+	for _, h := range report.HeldTx {
+		if !h.Survived {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Stress run FAILED")
+		return 1
+	}
+	log.Println("Stress run passed")
+	return 0
+}
@@ -6,13 +6,15 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
-// Reads each connection parameter from its own env var. Used to test the
+// By default reads each connection parameter from its own env var (see
+// params.go for the other PARAM_STYLE sources). Used to test the
 // db_branches "literal value" config form: the target pod is intentionally
 // deployed WITHOUT DB_PASSWORD, and the mirrord config provides the password
 // via { "env_var_name": "DB_PASSWORD", "value": "postgres" }. The app should
@@ -21,39 +23,30 @@ import (
 func main() {
 	log.Println("Starting PostgreSQL params app...")
 
-	host := mustGetEnv("DB_HOST")
-	port := mustGetEnv("DB_PORT")
-	user := mustGetEnv("DB_USER")
-	password := mustGetEnv("DB_PASSWORD")
-	database := mustGetEnv("DB_NAME")
-
-	log.Printf("DB_HOST=%s DB_PORT=%s DB_USER=%s DB_NAME=%s DB_PASSWORD=%s",
-		host, port, user, database, mask(password))
-
-	dsn := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, database,
-	)
-
-	db, err := sql.Open("postgres", dsn)
+	style := os.Getenv("PARAM_STYLE")
+	params, read, err := loadParams(style)
+	if style == "" {
+		style = "db"
+	}
+	log.Printf("PARAM_STYLE=%s read: %s", style, strings.Join(read, " "))
 	if err != nil {
-		log.Fatalf("sql.Open failed: %v", err)
+		log.Fatalf("Failed to load connection settings: %v", err)
 	}
+
+	db, host := connect(params)
 	defer db.Close()
+	log.Printf("Connected to PostgreSQL branch at %s", host)
 
-	var pingErr error
-	for i := 0; i < 10; i++ {
-		pingErr = db.Ping()
-		if pingErr == nil {
-			break
+	if params.sslmode != "disable" {
+		var ssl bool
+		var version, cipher sql.NullString
+		err := db.QueryRow("SELECT ssl, version, cipher FROM pg_stat_ssl WHERE pid = pg_backend_pid()").Scan(&ssl, &version, &cipher)
+		if err != nil {
+			log.Printf("pg_stat_ssl failed: %v", err)
+		} else {
+			log.Printf("TLS: ssl=%v version=%s cipher=%s (sslmode=%s)", ssl, version.String, cipher.String, params.sslmode)
 		}
-		log.Printf("Waiting for database (attempt %d/10): %v", i+1, pingErr)
-		time.Sleep(3 * time.Second)
-	}
-	if pingErr != nil {
-		log.Fatalf("Failed to connect: %v", pingErr)
 	}
-	log.Println("Connected to PostgreSQL branch")
 
 	var version string
 	if err := db.QueryRow("SELECT version()").Scan(&version); err != nil {
@@ -78,7 +71,7 @@ func main() {
 		log.Printf("user %d: %s", id, name)
 		count++
 	}
-	log.Printf("Read %d rows from users (literal-value flow worked)", count)
+	log.Printf("Read %d rows from users (PARAM_STYLE=%s flow worked)", count, style)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -87,12 +80,39 @@ func main() {
 	log.Println("Shutting down.")
 }
 
-func mustGetEnv(name string) string {
-	v := os.Getenv(name)
-	if v == "" {
-		log.Fatalf("required env var %s is not set", name)
+// connect tries each host in order, as libpq does with a host list, retrying
+// the whole list while the branch comes up. With target_session_attrs
+// read-write, hosts in recovery or read-only mode are skipped.
+func connect(p *connParams) (*sql.DB, string) {
+	var lastErr error
+	for attempt := 1; attempt <= 10; attempt++ {
+		for i, host := range p.hosts {
+			db, err := sql.Open("postgres", p.dsn(i))
+			if err == nil {
+				err = db.Ping()
+			}
+			if err == nil && p.targetSessionAttrs == "read-write" {
+				var readOnly string
+				if err = db.QueryRow("SHOW transaction_read_only").Scan(&readOnly); err == nil && readOnly == "on" {
+					err = fmt.Errorf("session is read-only")
+				}
+			}
+			if err == nil {
+				return db, host
+			}
+			if db != nil {
+				db.Close()
+			}
+			lastErr = err
+			if len(p.hosts) > 1 {
+				log.Printf("Host %s: %v", host, err)
+			}
+		}
+		log.Printf("Waiting for database (attempt %d/10): %v", attempt, lastErr)
+		time.Sleep(3 * time.Second)
 	}
-	return v
+	log.Fatalf("Failed to connect: %v", lastErr)
+	return nil, ""
 }
 
 func mask(s string) string {
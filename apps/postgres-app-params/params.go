@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PARAM_STYLE picks where the connection settings come from, so each way real
+// apps configure Postgres can be run against the operator's env override and
+// secret-ref flows:
+//
+//	db         DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME (default)
+//	libpq      PGHOST, PGPORT, PGUSER, PGPASSWORD, PGDATABASE, PGSSLMODE, ...
+//	passfile   the libpq variables, but the password only from PGPASSFILE
+//	tls        the libpq variables with PGSSLMODE defaulting to verify-full and
+//	           PGSSLROOTCERT/PGSSLCERT/PGSSLKEY required to be readable files
+//	service    PGSERVICE looked up in PGSERVICEFILE or PGSYSCONFDIR/pg_service.conf
+//	multihost  DATABASE_URL as postgresql://user:pass@h1:5432,h2:5433/db
+//
+// PGHOST and PGPORT may be comma-separated lists in every libpq-based style;
+// hosts are tried in order, as libpq does. Every variable the style looked at
+// is logged, set or not, with passwords masked. Variables lib/pq would panic
+// on (PGSYSCONFDIR, PGHOSTADDR, ...) are unset before connecting, with a log
+// line each.
+
+type connParams struct {
+	hosts              []string
+	ports              []string
+	user               string
+	password           string
+	dbname             string
+	sslmode            string
+	sslrootcert        string
+	sslcert            string
+	sslkey             string
+	appName            string
+	connectTimeout     string
+	targetSessionAttrs string
+}
+
+// envReader records every variable it is asked for.
+type envReader struct {
+	read []string
+}
+
+func (e *envReader) get(name string) string {
+	v, ok := os.LookupEnv(name)
+	switch {
+	case !ok:
+		e.read = append(e.read, name+" (unset)")
+	case strings.Contains(name, "PASSWORD"):
+		e.read = append(e.read, name+"="+mask(v))
+	case strings.Contains(v, "://"):
+		e.read = append(e.read, name+"="+maskURL(v))
+	default:
+		e.read = append(e.read, name+"="+v)
+	}
+	return v
+}
+
+// maskURL hides the password in a URI's userinfo.
+func maskURL(v string) string {
+	scheme := strings.Index(v, "://") + 3
+	at := strings.LastIndex(v, "@")
+	if at < scheme {
+		return v
+	}
+	colon := strings.Index(v[scheme:at], ":")
+	if colon < 0 {
+		return v
+	}
+	return v[:scheme+colon+1] + "***" + v[at:]
+}
+
+func (e *envReader) must(name string) string {
+	v := e.get(name)
+	if v == "" {
+		log.Fatalf("required env var %s is not set", name)
+	}
+	return v
+}
+
+func loadParams(style string) (*connParams, []string, error) {
+	env := &envReader{}
+	var p *connParams
+	var err error
+	switch style {
+	case "", "db":
+		p = &connParams{
+			hosts:    []string{env.must("DB_HOST")},
+			ports:    []string{env.must("DB_PORT")},
+			user:     env.must("DB_USER"),
+			password: env.must("DB_PASSWORD"),
+			dbname:   env.must("DB_NAME"),
+			sslmode:  "disable",
+		}
+	case "libpq":
+		p = libpqParams(env)
+		applyDefaults(p)
+		if p.password == "" {
+			p.password, err = passfilePassword(env, p)
+		}
+	case "passfile":
+		p = libpqParams(env)
+		applyDefaults(p)
+		p.password, err = passfilePassword(env, p)
+		if err == nil && p.password == "" {
+			err = fmt.Errorf("no matching entry in the password file")
+		}
+	case "tls":
+		p, err = tlsParams(env)
+	case "service":
+		p, err = serviceParams(env)
+	case "multihost":
+		p, err = urlParams(env.must("DATABASE_URL"))
+	default:
+		err = fmt.Errorf("unknown PARAM_STYLE %q (db, libpq, passfile, tls, service, multihost)", style)
+	}
+	if err != nil {
+		return nil, env.read, err
+	}
+	if len(p.ports) != 1 && len(p.ports) != len(p.hosts) {
+		return nil, env.read, fmt.Errorf("%d hosts but %d ports", len(p.hosts), len(p.ports))
+	}
+	unsetUnsupported()
+	return p, env.read, nil
+}
+
+// pqUnsupported are the libpq variables lib/pq panics on when it reads the
+// environment at connect time, whatever the DSN says. Some of them are real
+// inputs here (the service style reads PGSERVICE, PGSERVICEFILE and
+// PGSYSCONFDIR), so they are dropped only once the parameters are resolved.
+var pqUnsupported = []string{
+	"PGSERVICE", "PGSERVICEFILE", "PGSYSCONFDIR", "PGHOSTADDR", "PGREALM",
+	"PGSSLCRL", "PGREQUIRESSL", "PGREQUIREPEER", "PGKRBSRVNAME", "PGGSSLIB",
+	"PGLOCALEDIR",
+}
+
+func unsetUnsupported() {
+	for _, name := range pqUnsupported {
+		if _, ok := os.LookupEnv(name); ok {
+			os.Unsetenv(name)
+			log.Printf("Ignoring %s: lib/pq does not support it", name)
+		}
+	}
+}
+
+// libpqParams reads the standard libpq variables. Callers merge anything else
+// in and then call applyDefaults.
+func libpqParams(env *envReader) *connParams {
+	return &connParams{
+		hosts:              splitList(env.get("PGHOST")),
+		ports:              splitList(env.get("PGPORT")),
+		user:               env.get("PGUSER"),
+		password:           env.get("PGPASSWORD"),
+		dbname:             env.get("PGDATABASE"),
+		sslmode:            env.get("PGSSLMODE"),
+		sslrootcert:        env.get("PGSSLROOTCERT"),
+		sslcert:            env.get("PGSSLCERT"),
+		sslkey:             env.get("PGSSLKEY"),
+		appName:            env.get("PGAPPNAME"),
+		connectTimeout:     env.get("PGCONNECT_TIMEOUT"),
+		targetSessionAttrs: env.get("PGTARGETSESSIONATTRS"),
+	}
+}
+
+// applyDefaults fills in libpq's defaults, except that sslmode defaults to
+// disable like the rest of this repo's apps.
+func applyDefaults(p *connParams) {
+	if len(p.hosts) == 0 {
+		p.hosts = []string{"localhost"}
+	}
+	if len(p.ports) == 0 {
+		p.ports = []string{"5432"}
+	}
+	if p.user == "" {
+		p.user = os.Getenv("USER")
+	}
+	if p.dbname == "" {
+		p.dbname = p.user
+	}
+	if p.sslmode == "" {
+		p.sslmode = "disable"
+	}
+}
+
+func tlsParams(env *envReader) (*connParams, error) {
+	p := libpqParams(env)
+	if p.sslmode == "" {
+		p.sslmode = "verify-full"
+	}
+	applyDefaults(p)
+	switch p.sslmode {
+	case "require", "verify-ca", "verify-full":
+	default:
+		return nil, fmt.Errorf("PARAM_STYLE=tls needs PGSSLMODE require, verify-ca or verify-full, got %q", p.sslmode)
+	}
+	if p.sslmode != "require" && p.sslrootcert == "" {
+		return nil, fmt.Errorf("sslmode %s needs PGSSLROOTCERT", p.sslmode)
+	}
+	for name, path := range map[string]string{"PGSSLROOTCERT": p.sslrootcert, "PGSSLCERT": p.sslcert, "PGSSLKEY": p.sslkey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		log.Printf("%s file %s is readable", name, path)
+	}
+	if (p.sslcert == "") != (p.sslkey == "") {
+		return nil, fmt.Errorf("PGSSLCERT and PGSSLKEY must be set together")
+	}
+	if p.password == "" {
+		var err error
+		if p.password, err = passfilePassword(env, p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// serviceParams resolves PGSERVICE the way libpq does: service file values
+// win over the environment, which only fills in what the service leaves out.
+func serviceParams(env *envReader) (*connParams, error) {
+	service := env.must("PGSERVICE")
+	path := env.get("PGSERVICEFILE")
+	if path == "" {
+		dir := env.get("PGSYSCONFDIR")
+		if dir == "" {
+			home, _ := os.UserHomeDir()
+			path = filepath.Join(home, ".pg_service.conf")
+		} else {
+			path = filepath.Join(dir, "pg_service.conf")
+		}
+	}
+	values, err := readService(path, service)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Service %s from %s sets %s", service, path, strings.Join(sortedNames(values), ", "))
+
+	p := libpqParams(env)
+	for key, v := range values {
+		switch key {
+		case "host":
+			p.hosts = splitList(v)
+		case "port":
+			p.ports = splitList(v)
+		case "user":
+			p.user = v
+		case "password":
+			p.password = v
+		case "dbname":
+			p.dbname = v
+		case "sslmode":
+			p.sslmode = v
+		case "sslrootcert":
+			p.sslrootcert = v
+		case "sslcert":
+			p.sslcert = v
+		case "sslkey":
+			p.sslkey = v
+		case "application_name":
+			p.appName = v
+		case "connect_timeout":
+			p.connectTimeout = v
+		case "target_session_attrs":
+			p.targetSessionAttrs = v
+		default:
+			log.Printf("Ignoring service keyword %s", key)
+		}
+	}
+	applyDefaults(p)
+	if p.password == "" {
+		if p.password, err = passfilePassword(env, p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func readService(path, service string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("service file: %w", err)
+	}
+	defer f.Close()
+
+	var values map[string]string
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == service {
+				values = map[string]string{}
+			}
+			continue
+		}
+		if section != service {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: malformed line %q", path, line)
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, fmt.Errorf("service %q not found in %s", service, path)
+	}
+	return values, nil
+}
+
+// passfilePassword looks the first matching host:port:database:user line up
+// in PGPASSFILE (default ~/.pgpass), with * as a wildcard and \ escaping : and
+// \ like libpq. Only the first host is matched.
+func passfilePassword(env *envReader, p *connParams) (string, error) {
+	path := env.get("PGPASSFILE")
+	if path == "" {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, ".pgpass")
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && os.Getenv("PGPASSFILE") == "" {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("password file: %w", err)
+	}
+	defer f.Close()
+
+	want := []string{p.hosts[0], p.ports[0], p.dbname, p.user}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := splitPassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matched := true
+		for i, w := range want {
+			if fields[i] != "*" && fields[i] != w {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			log.Printf("Password from %s line %d", path, n)
+			return fields[4], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+func splitPassLine(line string) []string {
+	var fields []string
+	var cur strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case c == ':' && len(fields) < 4:
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(fields, cur.String())
+}
+
+// urlParams parses a libpq URI, which unlike net/url allows a comma-separated
+// host list in the authority.
+func urlParams(raw string) (*connParams, error) {
+	rest, ok := strings.CutPrefix(raw, "postgresql://")
+	if !ok {
+		if rest, ok = strings.CutPrefix(raw, "postgres://"); !ok {
+			return nil, fmt.Errorf("DATABASE_URL must start with postgresql://")
+		}
+	}
+	authority, path, _ := strings.Cut(rest, "/")
+	dbname, query, _ := strings.Cut(path, "?")
+
+	p := &connParams{}
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userinfo := authority[:i]
+		authority = authority[i+1:]
+		user, password, _ := strings.Cut(userinfo, ":")
+		var err error
+		if p.user, err = url.PathUnescape(user); err != nil {
+			return nil, fmt.Errorf("user: %w", err)
+		}
+		if p.password, err = url.PathUnescape(password); err != nil {
+			return nil, fmt.Errorf("password: %w", err)
+		}
+	}
+	for _, hp := range splitList(authority) {
+		host, port := hp, "5432"
+		if i := strings.LastIndex(hp, ":"); i >= 0 && !strings.HasSuffix(hp, "]") {
+			host, port = hp[:i], hp[i+1:]
+		}
+		p.hosts = append(p.hosts, strings.Trim(host, "[]"))
+		p.ports = append(p.ports, port)
+	}
+	var err error
+	if p.dbname, err = url.PathUnescape(dbname); err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
+
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	p.sslmode = q.Get("sslmode")
+	p.sslrootcert = q.Get("sslrootcert")
+	p.sslcert = q.Get("sslcert")
+	p.sslkey = q.Get("sslkey")
+	p.appName = q.Get("application_name")
+	p.connectTimeout = q.Get("connect_timeout")
+	p.targetSessionAttrs = q.Get("target_session_attrs")
+	applyDefaults(p)
+	return p, nil
+}
+
+// dsn renders the keyword/value string lib/pq gets for host i.
+func (p *connParams) dsn(i int) string {
+	port := p.ports[0]
+	if len(p.ports) > 1 {
+		port = p.ports[i]
+	}
+	kv := [][2]string{
+		{"host", p.hosts[i]}, {"port", port}, {"user", p.user}, {"password", p.password},
+		{"dbname", p.dbname}, {"sslmode", p.sslmode}, {"sslrootcert", p.sslrootcert},
+		{"sslcert", p.sslcert}, {"sslkey", p.sslkey}, {"application_name", p.appName},
+		{"connect_timeout", p.connectTimeout},
+	}
+	var parts []string
+	for _, pair := range kv {
+		if pair[1] == "" {
+			continue
+		}
+		v := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(pair[1])
+		parts = append(parts, fmt.Sprintf("%s='%s'", pair[0], v))
+	}
+	return strings.Join(parts, " ")
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func sortedNames(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
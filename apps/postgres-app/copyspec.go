@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VERIFY_COPY_SPEC=<file> checks a filtered branch copy table by table. The
+// file lists the filter each table was copied with, in the same shape as the
+// db_branches copy section:
+//
+//	{"tables": {"users": {"filter": "age >= 18"}, "orders": {"filter": "amount > 50"}}}
+//
+// A mirrord config can be passed as is; the copy section of its first
+// db_branches entry is used. For every listed table the check requires that
+// each branch row matches the filter, that each source row matching it is on
+// the branch, and that copied rows are unchanged. Rows are keyed by primary
+// key (the whole row when a table has none). A JSON report is printed and the
+// process exits 1 on any difference.
+
+const copySampleLimit = 10
+
+type tableSpec struct {
+	Filter string `json:"filter"`
+}
+
+type copySpec struct {
+	Tables map[string]tableSpec `json:"tables"`
+}
+
+type copyTableResult struct {
+	Table          string   `json:"table"`
+	Filter         string   `json:"filter"`
+	Key            []string `json:"key"`
+	SourceMatching int      `json:"source_matching"`
+	BranchRows     int      `json:"branch_rows"`
+	Missing        int      `json:"missing"`
+	NotMatching    int      `json:"not_matching"`
+	Changed        int      `json:"changed"`
+	Extra          int      `json:"extra"`
+	Samples        []string `json:"samples,omitempty"`
+	Error          string   `json:"error,omitempty"`
+	Passed         bool     `json:"passed"`
+}
+
+func (r *copyTableResult) sample(format string, args ...any) {
+	if len(r.Samples) < copySampleLimit {
+		r.Samples = append(r.Samples, fmt.Sprintf(format, args...))
+	}
+}
+
+type copySpecReport struct {
+	Engine     string            `json:"engine"`
+	Spec       string            `json:"spec"`
+	Passed     bool              `json:"passed"`
+	Branch     map[string]string `json:"branch"`
+	Source     map[string]string `json:"source"`
+	Tables     []copyTableResult `json:"tables"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+func loadCopySpec(path string) (*copySpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		copySpec
+		Feature struct {
+			DBBranches []struct {
+				Copy copySpec `json:"copy"`
+			} `json:"db_branches"`
+		} `json:"feature"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	spec := file.copySpec
+	if len(spec.Tables) == 0 && len(file.Feature.DBBranches) > 0 {
+		spec = file.Feature.DBBranches[0].Copy
+	}
+	if len(spec.Tables) == 0 {
+		return nil, fmt.Errorf("%s lists no tables", path)
+	}
+	return &spec, nil
+}
+
+func runCopySpecCheck(branch *sql.DB, path string) int {
+	start := time.Now()
+	report := &copySpecReport{Engine: "postgres", Spec: path, Tables: []copyTableResult{}}
+
+	spec, err := loadCopySpec(path)
+	if err != nil {
+		report.Error = err.Error()
+		return finishCopySpecReport(report, start)
+	}
+	source, err := openSource()
+	if err != nil {
+		report.Error = "source: " + err.Error()
+		return finishCopySpecReport(report, start)
+	}
+	defer source.Close()
+	report.Branch = pgIdentity(branch)
+	report.Source = pgIdentity(source)
+
+	tables := make([]string, 0, len(spec.Tables))
+	for name := range spec.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	for _, name := range tables {
+		report.Tables = append(report.Tables, checkCopiedTable(source, branch, name, spec.Tables[name].Filter))
+	}
+	return finishCopySpecReport(report, start)
+}
+
+func checkCopiedTable(source, branch *sql.DB, table, filter string) copyTableResult {
+	result := copyTableResult{Table: table, Filter: filter}
+	fail := func(err error) copyTableResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	cond := filter
+	if strings.TrimSpace(cond) == "" {
+		cond = "TRUE"
+	}
+	quoted := quoteQualified(table)
+
+	key, err := primaryKey(source, table)
+	if err != nil {
+		return fail(fmt.Errorf("primary key: %w", err))
+	}
+	result.Key = key
+
+	sourceRows, err := keyedRows(source, fmt.Sprintf("SELECT *, 1 AS copy_spec_match FROM %s WHERE %s", quoted, cond), key)
+	if err != nil {
+		return fail(fmt.Errorf("source: %w", err))
+	}
+	branchRows, err := keyedRows(branch, fmt.Sprintf("SELECT *, CASE WHEN %s THEN 1 ELSE 0 END AS copy_spec_match FROM %s", cond, quoted), key)
+	if err != nil {
+		return fail(fmt.Errorf("branch: %w", err))
+	}
+	compareCopiedRows(&result, sourceRows, branchRows)
+	return result
+}
+
+// keyedRow is one row rendered as text; matches is the filter evaluated on the
+// side the row was read from.
+type keyedRow struct {
+	key     string
+	text    string
+	matches bool
+}
+
+// keyedRows runs query, whose last column must be copy_spec_match, and keys
+// every row by the given columns.
+func keyedRows(db *sql.DB, query string, key []string) ([]keyedRow, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var out []keyedRow
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := keyedRow{matches: values[len(values)-1].String == "1"}
+		fields := make([]string, 0, len(columns)-1)
+		keyFields := make([]string, 0, len(key))
+		for i, col := range columns[:len(columns)-1] {
+			v := "NULL"
+			if values[i].Valid {
+				v = values[i].String
+			}
+			fields = append(fields, col+"="+v)
+			for _, k := range key {
+				if k == col {
+					keyFields = append(keyFields, col+"="+v)
+				}
+			}
+		}
+		row.text = strings.Join(fields, ", ")
+		row.key = strings.Join(keyFields, ", ")
+		if len(key) == 0 {
+			row.key = row.text
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+func compareCopiedRows(result *copyTableResult, sourceRows, branchRows []keyedRow) {
+	result.SourceMatching = len(sourceRows)
+	result.BranchRows = len(branchRows)
+
+	bySource := make(map[string]string, len(sourceRows))
+	for _, r := range sourceRows {
+		bySource[r.key] = r.text
+	}
+	onBranch := make(map[string]bool, len(branchRows))
+	for _, r := range branchRows {
+		onBranch[r.key] = true
+		srcText, fromSource := bySource[r.key]
+		switch {
+		case !r.matches:
+			result.NotMatching++
+			result.sample("not matching filter: %s", r.text)
+		case !fromSource:
+			result.Extra++
+			result.sample("not a matching source row: %s", r.text)
+		case srcText != r.text:
+			result.Changed++
+			result.sample("changed: branch {%s} source {%s}", r.text, srcText)
+		}
+	}
+	for _, r := range sourceRows {
+		if !onBranch[r.key] {
+			result.Missing++
+			result.sample("missing: %s", r.text)
+		}
+	}
+	result.Passed = result.Missing+result.NotMatching+result.Changed+result.Extra == 0
+}
+
+func primaryKey(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = $1::regclass AND i.indisprimary
+		ORDER BY array_position(i.indkey::int2[], a.attnum)
+	`, quoteQualified(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var key []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		key = append(key, col)
+	}
+	return key, rows.Err()
+}
+
+// quoteQualified quotes each part of a possibly schema-qualified name.
+func quoteQualified(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = `"` + strings.ReplaceAll(p, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func finishCopySpecReport(report *copySpecReport, start time.Time) int {
+	report.Passed = report.Error == ""
+	for _, t := range report.Tables {
+		if !t.Passed {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Copy spec check FAILED")
+		return 1
+	}
+	log.Println("Copy spec check passed")
+	return 0
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LISTEN_CHANNELS=orders,jobs keeps a dedicated LISTEN connection open next to
+// the API. Notifications and connection events (drops, reconnects) are logged
+// and kept for GET /notifications; POST /notify sends through the pool, so a
+// test can NOTIFY on the branch and see whether this listener - or one on the
+// source - receives it.
+
+const probeHistory = 100
+
+type notification struct {
+	Channel    string    `json:"channel"`
+	Payload    string    `json:"payload"`
+	BackendPID int       `json:"backend_pid"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type probeEvent struct {
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type listenProbe struct {
+	channels []string
+	listener *pq.Listener
+
+	mu            sync.Mutex
+	connected     bool
+	events        []probeEvent
+	notifications []notification
+}
+
+func startListenProbe(dbURL string, channels []string) (*listenProbe, error) {
+	p := &listenProbe{channels: channels}
+	p.listener = pq.NewListener(dbURL, time.Second, 30*time.Second, p.onEvent)
+	for _, ch := range channels {
+		if err := p.listener.Listen(ch); err != nil {
+			p.listener.Close()
+			return nil, err
+		}
+		log.Printf("LISTEN %s", ch)
+	}
+	go p.run()
+	return p, nil
+}
+
+func (p *listenProbe) onEvent(ev pq.ListenerEventType, err error) {
+	names := map[pq.ListenerEventType]string{
+		pq.ListenerEventConnected:               "connected",
+		pq.ListenerEventDisconnected:            "disconnected",
+		pq.ListenerEventReconnected:             "reconnected",
+		pq.ListenerEventConnectionAttemptFailed: "connection_attempt_failed",
+	}
+	e := probeEvent{Event: names[ev], At: time.Now()}
+	if err != nil {
+		e.Detail = err.Error()
+	}
+	log.Printf("Listener %s %s", e.Event, e.Detail)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connected = ev == pq.ListenerEventConnected || ev == pq.ListenerEventReconnected
+	p.events = appendCapped(p.events, e)
+}
+
+func (p *listenProbe) run() {
+	for {
+		select {
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			// pq sends nil after a reconnect: anything sent meanwhile is lost.
+			if n == nil {
+				continue
+			}
+			log.Printf("NOTIFY %s from pid %d: %s", n.Channel, n.BePid, n.Extra)
+			p.mu.Lock()
+			p.notifications = appendCapped(p.notifications, notification{
+				Channel: n.Channel, Payload: n.Extra, BackendPID: n.BePid, ReceivedAt: time.Now(),
+			})
+			p.mu.Unlock()
+		case <-time.After(90 * time.Second):
+			// Ping so a dead connection is noticed even when nothing is sent.
+			if err := p.listener.Ping(); err != nil {
+				log.Printf("Listener ping failed: %v", err)
+			}
+		}
+	}
+}
+
+func (p *listenProbe) status() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]interface{}{
+		"channels":      p.channels,
+		"connected":     p.connected,
+		"events":        append([]probeEvent{}, p.events...),
+		"notifications": append([]notification{}, p.notifications...),
+	}
+}
+
+func (p *listenProbe) close() {
+	p.listener.Close()
+}
+
+func appendCapped[T any](items []T, item T) []T {
+	items = append(items, item)
+	if len(items) > probeHistory {
+		items = items[len(items)-probeHistory:]
+	}
+	return items
+}
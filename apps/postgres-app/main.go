@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -57,6 +60,40 @@ func main() {
 
 	log.Println("Connected to PostgreSQL database")
 
+	// One-shot modes run instead of the normal startup and exit.
+	if isVerifyMode() {
+		code := runIsolationCheck(db)
+		db.Close()
+		os.Exit(code)
+	}
+	if isSchemaDiffMode() {
+		code := runSchemaDiff(db)
+		db.Close()
+		os.Exit(code)
+	}
+	if spec := os.Getenv("VERIFY_COPY_SPEC"); spec != "" {
+		code := runCopySpecCheck(db, spec)
+		db.Close()
+		os.Exit(code)
+	}
+	if isPgxCheckMode() {
+		code := runPgxCheck(db, dbURL)
+		db.Close()
+		os.Exit(code)
+	}
+	if isStressMode() {
+		code := runStress(db)
+		db.Close()
+		os.Exit(code)
+	}
+	if direction := os.Getenv("MIGRATE"); direction != "" {
+		if err := runMigrations(db, direction); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		db.Close()
+		os.Exit(0)
+	}
+
 	// Create table
 	createTableQuery := `
 		CREATE TABLE IF NOT EXISTS app_users (
@@ -97,7 +134,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to query users: %v", err)
 	}
-	defer rows.Close()
 
 	log.Println("Current users in database:")
 	count = 0
@@ -112,8 +148,41 @@ func main() {
 		count++
 		log.Printf("  - ID: %d, Name: %s, Created: %s", id, name, createdAt.Format(time.RFC3339))
 	}
+	rows.Close()
 	log.Printf("Total users: %d", count)
 
+	// Optional probes for features that need more than a plain connection.
+	var listen *listenProbe
+	if channels := splitCSV(os.Getenv("LISTEN_CHANNELS")); len(channels) > 0 {
+		listen, err = startListenProbe(dbURL, channels)
+		if err != nil {
+			log.Fatalf("Failed to start LISTEN probe: %v", err)
+		}
+		defer listen.close()
+	}
+	probeCtx, stopProbes := context.WithCancel(context.Background())
+	defer stopProbes()
+	var replication *replicationProbe
+	if slot := os.Getenv("REPLICATION_SLOT"); slot != "" {
+		replication = startReplicationProbe(probeCtx, db, dbURL, slot)
+	}
+
+	// Serve the CRUD API so tests can write through the app while a branch
+	// is active, instead of relying on the startup output above.
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	srv := &http.Server{Addr: ":" + port, Handler: newServer(db, listen, replication)}
+	// A failed listen (two local sessions on one port) only costs the API;
+	// the app keeps its database session, which is what most scenarios test.
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP API not available: %v", err)
+		}
+	}()
+	log.Printf("HTTP API listening on :%s", port)
+
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -123,6 +192,12 @@ func main() {
 	// Keep running
 	<-sigChan
 	log.Println("Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
 }
 
 func maskPassword(dsn string) string {
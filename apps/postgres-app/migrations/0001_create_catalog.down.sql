@@ -0,0 +1,4 @@
+DROP TABLE catalog_products;
+DROP TABLE categories;
+DROP SEQUENCE sku_seq;
+DROP TYPE product_status;
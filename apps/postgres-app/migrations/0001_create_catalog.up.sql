@@ -0,0 +1,26 @@
+CREATE TYPE product_status AS ENUM ('draft', 'active', 'discontinued');
+
+CREATE SEQUENCE sku_seq START WITH 1000 INCREMENT BY 10;
+
+CREATE TABLE categories (
+    id SERIAL PRIMARY KEY,
+    parent_id INT REFERENCES categories (id) ON DELETE CASCADE,
+    slug VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE catalog_products (
+    id BIGSERIAL PRIMARY KEY,
+    sku TEXT NOT NULL DEFAULT 'SKU-' || nextval('sku_seq'),
+    category_id INT NOT NULL REFERENCES categories (id),
+    name VARCHAR(255) NOT NULL,
+    status product_status NOT NULL DEFAULT 'draft',
+    price_cents INT NOT NULL CHECK (price_cents >= 0),
+    attributes JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT catalog_products_sku_key UNIQUE (sku)
+);
+
+CREATE INDEX catalog_products_category_idx ON catalog_products (category_id, status);
+CREATE INDEX catalog_products_active_idx ON catalog_products (name) WHERE status = 'active';
+CREATE INDEX catalog_products_attributes_idx ON catalog_products USING GIN (attributes);
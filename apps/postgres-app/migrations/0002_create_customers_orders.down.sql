@@ -0,0 +1,4 @@
+DROP TABLE order_items;
+DROP TABLE customer_orders;
+DROP TABLE customers;
+DROP TYPE order_status;
@@ -0,0 +1,31 @@
+CREATE TYPE order_status AS ENUM ('pending', 'paid', 'shipped', 'cancelled');
+
+CREATE TABLE customers (
+    id BIGSERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    full_name VARCHAR(255) NOT NULL,
+    country CHAR(2) NOT NULL DEFAULT 'US',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX customers_email_lower_idx ON customers (lower(email));
+
+CREATE TABLE customer_orders (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id BIGINT NOT NULL REFERENCES customers (id) ON DELETE RESTRICT,
+    status order_status NOT NULL DEFAULT 'pending',
+    placed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    shipped_at TIMESTAMPTZ,
+    CONSTRAINT customer_orders_shipped_after_placed CHECK (shipped_at IS NULL OR shipped_at >= placed_at)
+);
+
+CREATE INDEX customer_orders_customer_idx ON customer_orders (customer_id, placed_at DESC);
+
+CREATE TABLE order_items (
+    order_id BIGINT NOT NULL REFERENCES customer_orders (id) ON DELETE CASCADE,
+    product_id BIGINT NOT NULL REFERENCES catalog_products (id),
+    quantity INT NOT NULL CHECK (quantity > 0),
+    unit_price_cents INT NOT NULL,
+    line_total_cents INT GENERATED ALWAYS AS (quantity * unit_price_cents) STORED,
+    PRIMARY KEY (order_id, product_id)
+);
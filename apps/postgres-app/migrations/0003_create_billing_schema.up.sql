@@ -0,0 +1,18 @@
+CREATE SCHEMA billing;
+
+CREATE SEQUENCE billing.invoice_number_seq AS INT START WITH 50000 INCREMENT BY 1 MAXVALUE 99999999 NO CYCLE;
+
+CREATE TABLE billing.invoices (
+    id BIGSERIAL PRIMARY KEY,
+    number INT NOT NULL DEFAULT nextval('billing.invoice_number_seq'),
+    order_id BIGINT NOT NULL REFERENCES public.customer_orders (id),
+    amount_cents BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT invoices_number_key UNIQUE (number),
+    CONSTRAINT invoices_amount_positive CHECK (amount_cents > 0)
+);
+
+ALTER SEQUENCE billing.invoice_number_seq OWNED BY billing.invoices.number;
+
+CREATE INDEX invoices_order_idx ON billing.invoices (order_id);
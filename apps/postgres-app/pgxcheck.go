@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PGX_CHECK=1 exercises the branch through pgx/v5 instead of lib/pq, covering
+// the parts of the wire protocol the rest of the app never touches: named
+// prepared statements, every extended-protocol exec mode, COPY FROM STDIN and
+// COPY TO STDOUT, pipelined batches and LISTEN with a NOTIFY sent from the
+// lib/pq pool. Each feature is a separate check, so the JSON report shows
+// which ones a proxy in front of the branch breaks; the process exits 1 if any
+// failed.
+//
+// pgx honours sslmode=require|verify-ca|verify-full and sslrootcert from
+// DATABASE_URL. PGX_SSLMODE overrides sslmode for this check only, so TLS can
+// be tried without changing how the rest of the app connects.
+
+const pgxCopyRows = 1000
+
+type pgxReport struct {
+	Engine     string            `json:"engine"`
+	Driver     string            `json:"driver"`
+	Passed     bool              `json:"passed"`
+	Server     map[string]string `json:"server"`
+	Checks     []check           `json:"checks"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+// run records fn as one check. Checks keep going after a failure, since the
+// point is to see exactly which features work.
+func (r *pgxReport) run(name string, fn func() (string, error)) {
+	detail, err := fn()
+	if err != nil {
+		r.Checks = append(r.Checks, check{Name: name, Passed: false, Detail: err.Error()})
+		log.Printf("pgx %s: FAILED: %v", name, err)
+		return
+	}
+	r.Checks = append(r.Checks, check{Name: name, Passed: true, Detail: detail})
+	log.Printf("pgx %s: ok %s", name, detail)
+}
+
+func isPgxCheckMode() bool {
+	v := os.Getenv("PGX_CHECK")
+	return v == "1" || v == "true"
+}
+
+func runPgxCheck(db *sql.DB, dbURL string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	report := &pgxReport{Engine: "postgres", Driver: "pgx/v5", Server: map[string]string{}, Checks: []check{}}
+
+	if mode := os.Getenv("PGX_SSLMODE"); mode != "" {
+		dbURL = setParam(dbURL, "sslmode", mode)
+	}
+	config, err := pgx.ParseConfig(dbURL)
+	if err != nil {
+		report.Checks = append(report.Checks, check{Name: "connect", Detail: "parse config: " + err.Error()})
+		return finishPgxReport(report, start)
+	}
+
+	var conn *pgx.Conn
+	report.run("connect", func() (string, error) {
+		conn, err = pgx.ConnectConfig(ctx, config)
+		if err != nil {
+			return "", err
+		}
+		if tlsConn, ok := conn.PgConn().Conn().(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			report.Server["tls"] = tls.VersionName(state.Version)
+			return "tls " + tls.VersionName(state.Version), nil
+		}
+		report.Server["tls"] = "off"
+		return "no tls", nil
+	})
+	if conn == nil {
+		return finishPgxReport(report, start)
+	}
+	defer conn.Close(context.Background())
+
+	var database, version string
+	if err := conn.QueryRow(ctx, "SELECT current_database(), version()").Scan(&database, &version); err == nil {
+		report.Server["database"] = database
+		report.Server["version"] = version
+	}
+	report.Server["backend_pid"] = fmt.Sprint(conn.PgConn().PID())
+
+	report.run("prepared_statement", func() (string, error) {
+		const name = "pgx_check_sum"
+		if _, err := conn.Prepare(ctx, name, "SELECT $1::int + $2::int"); err != nil {
+			return "", fmt.Errorf("prepare: %w", err)
+		}
+		var sum int
+		if err := conn.QueryRow(ctx, name, 40, 2).Scan(&sum); err != nil {
+			return "", fmt.Errorf("execute: %w", err)
+		}
+		if sum != 42 {
+			return "", fmt.Errorf("got %d, want 42", sum)
+		}
+		// The statement must live on the backend we think we are talking to.
+		var found bool
+		if err := conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_prepared_statements WHERE name = $1)", name).Scan(&found); err != nil {
+			return "", fmt.Errorf("pg_prepared_statements: %w", err)
+		}
+		if !found {
+			return "", errors.New("statement missing from pg_prepared_statements")
+		}
+		if err := conn.Deallocate(ctx, name); err != nil {
+			return "", fmt.Errorf("deallocate: %w", err)
+		}
+		return "prepared, executed and deallocated " + name, nil
+	})
+
+	report.run("exec_modes", func() (string, error) {
+		modes := map[string]pgx.QueryExecMode{
+			"cache_statement": pgx.QueryExecModeCacheStatement,
+			"cache_describe":  pgx.QueryExecModeCacheDescribe,
+			"describe_exec":   pgx.QueryExecModeDescribeExec,
+			"exec":            pgx.QueryExecModeExec,
+			"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+		}
+		var failed []string
+		for name, mode := range modes {
+			var got string
+			err := conn.QueryRow(ctx, "SELECT $1::text || '-' || $2::int", mode, name, 7).Scan(&got)
+			if err != nil || got != name+"-7" {
+				failed = append(failed, fmt.Sprintf("%s: %v %q", name, err, got))
+			}
+		}
+		if len(failed) > 0 {
+			return "", errors.New(strings.Join(failed, "; "))
+		}
+		return fmt.Sprintf("%d modes", len(modes)), nil
+	})
+
+	// The COPY checks use a temp table so nothing is left behind on the branch.
+	_, err = conn.Exec(ctx, `CREATE TEMP TABLE pgx_check_copy (id int PRIMARY KEY, name text, payload bytea, created_at timestamptz)`)
+	tableErr := err
+
+	report.run("copy_from", func() (string, error) {
+		if tableErr != nil {
+			return "", fmt.Errorf("create temp table: %w", tableErr)
+		}
+		rows := make([][]any, pgxCopyRows)
+		for i := range rows {
+			rows[i] = []any{i + 1, fmt.Sprintf("row-%d", i+1), []byte{byte(i), 0, 0xff}, time.Now()}
+		}
+		n, err := conn.CopyFrom(ctx, pgx.Identifier{"pgx_check_copy"},
+			[]string{"id", "name", "payload", "created_at"}, pgx.CopyFromRows(rows))
+		if err != nil {
+			return "", err
+		}
+		if n != pgxCopyRows {
+			return "", fmt.Errorf("copied %d rows, want %d", n, pgxCopyRows)
+		}
+		return fmt.Sprintf("%d rows", n), nil
+	})
+
+	report.run("copy_to", func() (string, error) {
+		if tableErr != nil {
+			return "", fmt.Errorf("create temp table: %w", tableErr)
+		}
+		var buf bytes.Buffer
+		tag, err := conn.PgConn().CopyTo(ctx, &buf, "COPY pgx_check_copy TO STDOUT")
+		if err != nil {
+			return "", err
+		}
+		lines := bytes.Count(buf.Bytes(), []byte("\n"))
+		if tag.RowsAffected() != pgxCopyRows || lines != pgxCopyRows {
+			return "", fmt.Errorf("command tag %d rows, %d lines, want %d", tag.RowsAffected(), lines, pgxCopyRows)
+		}
+		return fmt.Sprintf("%d rows, %d bytes", lines, buf.Len()), nil
+	})
+
+	report.run("batch", func() (string, error) {
+		batch := &pgx.Batch{}
+		batch.Queue("SELECT 1")
+		batch.Queue("SELECT $1::text", "pipelined")
+		batch.Queue("SELECT count(*) FROM generate_series(1, $1::int)", 500)
+		batch.Queue("SELECT current_setting('application_name')")
+		results := conn.SendBatch(ctx, batch)
+
+		var one, count int64
+		var text, app string
+		scans := []func(pgx.Row) error{
+			func(r pgx.Row) error { return r.Scan(&one) },
+			func(r pgx.Row) error { return r.Scan(&text) },
+			func(r pgx.Row) error { return r.Scan(&count) },
+			func(r pgx.Row) error { return r.Scan(&app) },
+		}
+		for i, scan := range scans {
+			if err := scan(results.QueryRow()); err != nil {
+				results.Close()
+				return "", fmt.Errorf("query %d: %w", i+1, err)
+			}
+		}
+		if err := results.Close(); err != nil {
+			return "", err
+		}
+		if one != 1 || text != "pipelined" || count != 500 {
+			return "", fmt.Errorf("unexpected results %d %q %d", one, text, count)
+		}
+		return fmt.Sprintf("%d queries in one round trip", batch.Len()), nil
+	})
+
+	report.run("listen", func() (string, error) {
+		channel := fmt.Sprintf("pgx_check_%d", time.Now().UnixNano())
+		if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+			return "", fmt.Errorf("listen: %w", err)
+		}
+		// Sent from the lib/pq pool: a different session, possibly a
+		// different proxied connection, but it must reach the same server.
+		if _, err := db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, "pgx-check"); err != nil {
+			return "", fmt.Errorf("notify: %w", err)
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		n, err := conn.WaitForNotification(waitCtx)
+		if err != nil {
+			return "", fmt.Errorf("wait for notification: %w", err)
+		}
+		if n.Channel != channel || n.Payload != "pgx-check" {
+			return "", fmt.Errorf("got %s %q", n.Channel, n.Payload)
+		}
+		return fmt.Sprintf("notification from pid %d", n.PID), nil
+	})
+
+	return finishPgxReport(report, start)
+}
+
+// setParam sets key in a postgres URL, replacing any value already there.
+func setParam(dbURL, key, value string) string {
+	base, query, _ := strings.Cut(dbURL, "?")
+	var params []string
+	for _, p := range strings.Split(query, "&") {
+		if p != "" && !strings.HasPrefix(p, key+"=") {
+			params = append(params, p)
+		}
+	}
+	params = append(params, key+"="+value)
+	return base + "?" + strings.Join(params, "&")
+}
+
+func finishPgxReport(report *pgxReport, start time.Time) int {
+	report.Passed = len(report.Checks) > 0
+	for _, c := range report.Checks {
+		if !c.Passed {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("pgx protocol check FAILED")
+		return 1
+	}
+	log.Println("pgx protocol check passed")
+	return 0
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// REPLICATION_SLOT=<name> streams logical replication from DATABASE_URL next
+// to the API, the way CDC consumers do. The probe creates a publication
+// (REPLICATION_PUBLICATION, default mirrord_probe) for REPLICATION_TABLES
+// (default app_users, "*" for all tables) and a pgoutput slot, temporary
+// unless REPLICATION_TEMPORARY=false. Decoded changes are logged and kept for
+// GET /replication, so writing through POST /users shows whether the branch
+// supports logical decoding at all (wal_level, replication privileges).
+
+const standbyTimeout = 10 * time.Second
+
+type replicationChange struct {
+	Kind   string            `json:"kind"`
+	Table  string            `json:"table,omitempty"`
+	Values map[string]string `json:"values,omitempty"`
+	LSN    string            `json:"lsn"`
+	At     time.Time         `json:"at"`
+}
+
+type replicationProbe struct {
+	slot        string
+	publication string
+	tables      []string
+	temporary   bool
+
+	mu       sync.Mutex
+	state    string
+	lastErr  string
+	walLevel string
+	startLSN string
+	changes  []replicationChange
+}
+
+func startReplicationProbe(ctx context.Context, db *sql.DB, dbURL, slot string) *replicationProbe {
+	p := &replicationProbe{
+		slot:        slot,
+		publication: getEnvDefault("REPLICATION_PUBLICATION", "mirrord_probe"),
+		tables:      splitCSV(getEnvDefault("REPLICATION_TABLES", "app_users")),
+		temporary:   getEnvDefault("REPLICATION_TEMPORARY", "true") != "false",
+		state:       "starting",
+	}
+	go func() {
+		err := p.run(ctx, db, withParam(dbURL, "replication", "database"))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Replication probe failed: %v", err)
+			p.setState("failed", err)
+			return
+		}
+		p.setState("stopped", nil)
+	}()
+	return p
+}
+
+func (p *replicationProbe) run(ctx context.Context, db *sql.DB, replURL string) error {
+	var walLevel string
+	if err := db.QueryRowContext(ctx, "SHOW wal_level").Scan(&walLevel); err != nil {
+		return fmt.Errorf("read wal_level: %w", err)
+	}
+	p.mu.Lock()
+	p.walLevel = walLevel
+	p.mu.Unlock()
+	log.Printf("Replication probe: wal_level=%s", walLevel)
+	if err := p.ensurePublication(ctx, db); err != nil {
+		return err
+	}
+
+	conn, err := pgconn.Connect(ctx, replURL)
+	if err != nil {
+		return fmt.Errorf("replication connection: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	sys, err := pglogrepl.IdentifySystem(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("IDENTIFY_SYSTEM: %w", err)
+	}
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, p.slot, "pgoutput",
+		pglogrepl.CreateReplicationSlotOptions{Temporary: p.temporary})
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr) && pgErr.Code == "42710" && !p.temporary:
+		log.Printf("Replication slot %s already exists, reusing it", p.slot)
+	case err != nil:
+		return fmt.Errorf("create slot %s: %w", p.slot, err)
+	default:
+		log.Printf("Created replication slot %s (temporary=%v)", p.slot, p.temporary)
+	}
+
+	err = pglogrepl.StartReplication(ctx, conn, p.slot, sys.XLogPos, pglogrepl.StartReplicationOptions{
+		PluginArgs: []string{"proto_version '1'", "publication_names " + quoteLiteral(pgx.Identifier{p.publication}.Sanitize())},
+	})
+	if err != nil {
+		return fmt.Errorf("START_REPLICATION: %w", err)
+	}
+	p.mu.Lock()
+	p.startLSN = sys.XLogPos.String()
+	p.mu.Unlock()
+	p.setState("streaming", nil)
+	log.Printf("Streaming changes from slot %s at %s", p.slot, sys.XLogPos)
+
+	return p.stream(ctx, conn, sys.XLogPos)
+}
+
+func (p *replicationProbe) ensurePublication(ctx context.Context, db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)", p.publication).Scan(&exists); err != nil {
+		return fmt.Errorf("check publication: %w", err)
+	}
+	if exists {
+		log.Printf("Using existing publication %s", p.publication)
+		return nil
+	}
+
+	target := "ALL TABLES"
+	if len(p.tables) != 1 || p.tables[0] != "*" {
+		quoted := make([]string, len(p.tables))
+		for i, t := range p.tables {
+			quoted[i] = quoteQualified(t)
+		}
+		target = "TABLE " + strings.Join(quoted, ", ")
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE PUBLICATION %s FOR %s", pgx.Identifier{p.publication}.Sanitize(), target)); err != nil {
+		return fmt.Errorf("create publication: %w", err)
+	}
+	log.Printf("Created publication %s FOR %s", p.publication, target)
+	return nil
+}
+
+func (p *replicationProbe) stream(ctx context.Context, conn *pgconn.PgConn, pos pglogrepl.LSN) error {
+	relations := map[uint32]*pglogrepl.RelationMessage{}
+	nextStatus := time.Now().Add(standbyTimeout)
+
+	for {
+		if time.Now().After(nextStatus) {
+			if err := pglogrepl.SendStandbyStatusUpdate(ctx, conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: pos}); err != nil {
+				return fmt.Errorf("standby status update: %w", err)
+			}
+			nextStatus = time.Now().Add(standbyTimeout)
+		}
+
+		recvCtx, cancel := context.WithDeadline(ctx, nextStatus)
+		raw, err := conn.ReceiveMessage(recvCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if pgconn.Timeout(err) {
+				continue
+			}
+			return fmt.Errorf("receive: %w", err)
+		}
+		if errMsg, ok := raw.(*pgproto3.ErrorResponse); ok {
+			return fmt.Errorf("server error: %s (%s)", errMsg.Message, errMsg.Code)
+		}
+		msg, ok := raw.(*pgproto3.CopyData)
+		if !ok {
+			continue
+		}
+
+		switch msg.Data[0] {
+		case pglogrepl.PrimaryKeepaliveMessageByteID:
+			pkm, err := pglogrepl.ParsePrimaryKeepaliveMessage(msg.Data[1:])
+			if err != nil {
+				return fmt.Errorf("parse keepalive: %w", err)
+			}
+			if pkm.ServerWALEnd > pos {
+				pos = pkm.ServerWALEnd
+			}
+			if pkm.ReplyRequested {
+				nextStatus = time.Time{}
+			}
+		case pglogrepl.XLogDataByteID:
+			xld, err := pglogrepl.ParseXLogData(msg.Data[1:])
+			if err != nil {
+				return fmt.Errorf("parse XLogData: %w", err)
+			}
+			if err := p.decode(xld, relations); err != nil {
+				return err
+			}
+			if end := xld.WALStart + pglogrepl.LSN(len(xld.WALData)); end > pos {
+				pos = end
+			}
+		}
+	}
+}
+
+func (p *replicationProbe) decode(xld pglogrepl.XLogData, relations map[uint32]*pglogrepl.RelationMessage) error {
+	msg, err := pglogrepl.Parse(xld.WALData)
+	if err != nil {
+		return fmt.Errorf("parse pgoutput message: %w", err)
+	}
+	change := replicationChange{LSN: xld.WALStart.String(), At: time.Now()}
+	tableName := func(id uint32) (*pglogrepl.RelationMessage, string) {
+		rel, ok := relations[id]
+		if !ok {
+			return nil, fmt.Sprintf("relation %d", id)
+		}
+		return rel, rel.Namespace + "." + rel.RelationName
+	}
+
+	switch m := msg.(type) {
+	case *pglogrepl.RelationMessage:
+		relations[m.RelationID] = m
+		return nil
+	case *pglogrepl.BeginMessage:
+		change.Kind = "begin"
+		change.Values = map[string]string{"xid": fmt.Sprint(m.Xid)}
+	case *pglogrepl.CommitMessage:
+		change.Kind = "commit"
+	case *pglogrepl.InsertMessage:
+		rel, name := tableName(m.RelationID)
+		change.Kind, change.Table, change.Values = "insert", name, tupleValues(rel, m.Tuple)
+	case *pglogrepl.UpdateMessage:
+		rel, name := tableName(m.RelationID)
+		change.Kind, change.Table, change.Values = "update", name, tupleValues(rel, m.NewTuple)
+	case *pglogrepl.DeleteMessage:
+		rel, name := tableName(m.RelationID)
+		change.Kind, change.Table, change.Values = "delete", name, tupleValues(rel, m.OldTuple)
+	case *pglogrepl.TruncateMessage:
+		change.Kind = "truncate"
+	default:
+		return nil
+	}
+
+	if change.Table != "" {
+		log.Printf("Replication %s %s %v", change.Kind, change.Table, change.Values)
+	}
+	p.mu.Lock()
+	p.changes = appendCapped(p.changes, change)
+	p.mu.Unlock()
+	return nil
+}
+
+// tupleValues renders a tuple as text; unchanged TOAST values are marked as
+// such because pgoutput does not resend them.
+func tupleValues(rel *pglogrepl.RelationMessage, tuple *pglogrepl.TupleData) map[string]string {
+	if tuple == nil {
+		return nil
+	}
+	values := map[string]string{}
+	for i, col := range tuple.Columns {
+		name := fmt.Sprintf("col%d", i)
+		if rel != nil && i < len(rel.Columns) {
+			name = rel.Columns[i].Name
+		}
+		switch col.DataType {
+		case pglogrepl.TupleDataTypeNull:
+			values[name] = "NULL"
+		case pglogrepl.TupleDataTypeToast:
+			values[name] = "(unchanged toast)"
+		default:
+			values[name] = string(col.Data)
+		}
+	}
+	return values
+}
+
+// quoteLiteral quotes s as a SQL string literal. publication_names is a
+// literal holding a list of identifiers, so the name is quoted twice.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (p *replicationProbe) setState(state string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = state
+	if err != nil {
+		p.lastErr = err.Error()
+	}
+}
+
+func (p *replicationProbe) status() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]interface{}{
+		"state":       p.state,
+		"error":       p.lastErr,
+		"wal_level":   p.walLevel,
+		"slot":        p.slot,
+		"temporary":   p.temporary,
+		"publication": p.publication,
+		"tables":      p.tables,
+		"start_lsn":   p.startLSN,
+		"changes":     append([]replicationChange{}, p.changes...),
+	}
+}
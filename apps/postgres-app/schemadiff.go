@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SCHEMA_DIFF=1 compares the schema of the branch (DATABASE_URL) with the
+// source (SOURCE_DATABASE_URL) and prints a JSON report: tables, columns,
+// indexes, constraints, sequences and enums that exist on only one side or
+// are defined differently. It exits 0 when the schemas are identical.
+// SCHEMA_DIFF_IGNORE is a CSV of object names (e.g. public.app_users) to
+// leave out, together with everything that belongs to them.
+
+type schemaDiff struct {
+	OnlyInBranch []string        `json:"only_in_branch"`
+	OnlyInSource []string        `json:"only_in_source"`
+	Different    []objectChanged `json:"different"`
+	BranchCount  int             `json:"branch_count"`
+	SourceCount  int             `json:"source_count"`
+}
+
+type objectChanged struct {
+	Name   string `json:"name"`
+	Branch string `json:"branch"`
+	Source string `json:"source"`
+}
+
+type schemaDiffReport struct {
+	Engine     string                 `json:"engine"`
+	Identical  bool                   `json:"identical"`
+	Branch     map[string]string      `json:"branch"`
+	Source     map[string]string      `json:"source"`
+	Categories map[string]*schemaDiff `json:"categories"`
+}
+
+// schemaQueries return (name, definition) pairs per category. Names are
+// schema-qualified so objects in different schemas never collide. Sequences
+// also return the table that owns them (serial, OWNED BY or identity), since
+// their names do not start with the table's.
+var schemaQueries = map[string]string{
+	"tables": `
+		SELECT table_schema || '.' || table_name, table_type
+		FROM information_schema.tables
+		WHERE table_schema NOT IN ('pg_catalog', 'information_schema')`,
+	"columns": `
+		SELECT table_schema || '.' || table_name || '.' || column_name,
+		       concat_ws(' ',
+		           CASE WHEN data_type = 'USER-DEFINED' THEN udt_name ELSE data_type END,
+		           CASE WHEN character_maximum_length IS NOT NULL THEN '(' || character_maximum_length || ')' END,
+		           CASE WHEN data_type = 'numeric' AND numeric_precision IS NOT NULL
+		                THEN '(' || numeric_precision || ',' || numeric_scale || ')' END,
+		           CASE WHEN datetime_precision IS NOT NULL THEN 'precision ' || datetime_precision END,
+		           CASE WHEN is_nullable = 'NO' THEN 'NOT NULL' END,
+		           CASE WHEN column_default IS NOT NULL THEN 'DEFAULT ' || column_default END,
+		           CASE WHEN is_generated = 'ALWAYS' THEN 'GENERATED ' || generation_expression END,
+		           CASE WHEN is_identity = 'YES' THEN 'IDENTITY ' || identity_generation END)
+		FROM information_schema.columns
+		WHERE table_schema NOT IN ('pg_catalog', 'information_schema')`,
+	"indexes": `
+		SELECT schemaname || '.' || tablename || '.' || indexname, indexdef
+		FROM pg_indexes
+		WHERE schemaname NOT IN ('pg_catalog', 'information_schema')`,
+	"constraints": `
+		SELECT n.nspname || '.' || c.relname || '.' || con.conname,
+		       con.contype || ' ' || pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')`,
+	"sequences": `
+		SELECT s.schemaname || '.' || s.sequencename,
+		       concat_ws(' ', s.data_type, 'start', s.start_value, 'increment', s.increment_by,
+		                 'min', s.min_value, 'max', s.max_value, 'cycle', s.cycle),
+		       coalesce(tn.nspname || '.' || t.relname, '')
+		FROM pg_sequences s
+		LEFT JOIN pg_depend d ON d.classid = 'pg_class'::regclass
+		      AND d.objid = format('%I.%I', s.schemaname, s.sequencename)::regclass
+		      AND d.refclassid = 'pg_class'::regclass
+		      AND d.deptype IN ('a', 'i')
+		LEFT JOIN pg_class t ON t.oid = d.refobjid
+		LEFT JOIN pg_namespace tn ON tn.oid = t.relnamespace`,
+	"enums": `
+		SELECT n.nspname || '.' || t.typname, string_agg(e.enumlabel, ',' ORDER BY e.enumsortorder)
+		FROM pg_type t
+		JOIN pg_enum e ON e.enumtypid = t.oid
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		GROUP BY n.nspname, t.typname`,
+}
+
+func isSchemaDiffMode() bool {
+	v := os.Getenv("SCHEMA_DIFF")
+	return v == "1" || v == "true"
+}
+
+func runSchemaDiff(branch *sql.DB) int {
+	source, err := openSource()
+	if err != nil {
+		log.Printf("Failed to connect to source: %v", err)
+		return 1
+	}
+	defer source.Close()
+
+	report := &schemaDiffReport{
+		Engine:     "postgres",
+		Identical:  true,
+		Branch:     pgIdentity(branch),
+		Source:     pgIdentity(source),
+		Categories: map[string]*schemaDiff{},
+	}
+	ignore := splitCSV(os.Getenv("SCHEMA_DIFF_IGNORE"))
+
+	for category, query := range schemaQueries {
+		branchObjects, err := schemaObjects(branch, query, ignore)
+		if err != nil {
+			log.Printf("Failed to read %s from branch: %v", category, err)
+			return 1
+		}
+		sourceObjects, err := schemaObjects(source, query, ignore)
+		if err != nil {
+			log.Printf("Failed to read %s from source: %v", category, err)
+			return 1
+		}
+		diff := diffObjects(branchObjects, sourceObjects)
+		if len(diff.OnlyInBranch)+len(diff.OnlyInSource)+len(diff.Different) > 0 {
+			report.Identical = false
+		}
+		report.Categories[category] = diff
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Identical {
+		log.Println("Schemas differ")
+		return 1
+	}
+	log.Println("Schemas are identical")
+	return 0
+}
+
+func schemaObjects(db *sql.DB, query string, ignore []string) (map[string]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	objects := map[string]string{}
+	for rows.Next() {
+		var name, definition, owner string
+		dest := []interface{}{&name, &definition}
+		if len(cols) > 2 {
+			dest = append(dest, &owner)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if ignored(name, ignore) || (owner != "" && ignored(owner, ignore)) {
+			continue
+		}
+		objects[name] = definition
+	}
+	return objects, rows.Err()
+}
+
+func diffObjects(branch, source map[string]string) *schemaDiff {
+	diff := &schemaDiff{
+		OnlyInBranch: []string{},
+		OnlyInSource: []string{},
+		Different:    []objectChanged{},
+		BranchCount:  len(branch),
+		SourceCount:  len(source),
+	}
+	for name, def := range branch {
+		srcDef, ok := source[name]
+		switch {
+		case !ok:
+			diff.OnlyInBranch = append(diff.OnlyInBranch, name)
+		case srcDef != def:
+			diff.Different = append(diff.Different, objectChanged{Name: name, Branch: def, Source: srcDef})
+		}
+	}
+	for name := range source {
+		if _, ok := branch[name]; !ok {
+			diff.OnlyInSource = append(diff.OnlyInSource, name)
+		}
+	}
+	sort.Strings(diff.OnlyInBranch)
+	sort.Strings(diff.OnlyInSource)
+	sort.Slice(diff.Different, func(i, j int) bool { return diff.Different[i].Name < diff.Different[j].Name })
+	return diff
+}
+
+// ignored reports whether name is one of the ignored objects or belongs to
+// one (public.app_users also drops public.app_users.id and its indexes).
+// Sequences are matched on their owning table as well, by schemaObjects.
+func ignored(name string, ignore []string) bool {
+	for _, prefix := range ignore {
+		if name == prefix || strings.HasPrefix(name, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func splitCSV(csv string) []string {
+	var items []string
+	for _, s := range strings.Split(csv, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type appUser struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type userRequest struct {
+	Name string `json:"name"`
+}
+
+// server exposes app_users over HTTP so a test can write through the app
+// while a branch is active and then check the source with query:source.
+type server struct {
+	db          *sql.DB
+	listen      *listenProbe
+	replication *replicationProbe
+}
+
+// newServer takes the optional probes started from LISTEN_CHANNELS and
+// REPLICATION_SLOT; either may be nil.
+func newServer(db *sql.DB, listen *listenProbe, replication *replicationProbe) http.Handler {
+	s := &server{db: db, listen: listen, replication: replication}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/whoami", s.handleWhoami)
+	mux.HandleFunc("/users", s.handleUsers)
+	mux.HandleFunc("/users/", s.handleUser)
+	mux.HandleFunc("/notify", s.handleNotify)
+	mux.HandleFunc("/notifications", s.handleNotifications)
+	mux.HandleFunc("/replication", s.handleReplication)
+	return logRequests(mux)
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"service": "postgres-app",
+		"endpoints": []string{
+			"GET /health",
+			"GET /whoami",
+			"GET /users",
+			"POST /users {\"name\":\"...\"}",
+			"GET /users/{id}",
+			"PUT /users/{id} {\"name\":\"...\"}",
+			"DELETE /users/{id}",
+			"POST /notify {\"channel\":\"...\",\"payload\":\"...\"}",
+			"GET /notifications",
+			"GET /replication",
+		},
+	})
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.PingContext(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleWhoami reports which server and database the app is really talking
+// to. With a branch active this is the branch pod, not postgres-test.
+// The backend pid belongs to whichever pooled connection ran the query.
+func (s *server) handleWhoami(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	var (
+		database, user, version string
+		serverAddr              sql.NullString
+		serverPort              sql.NullInt64
+		pid                     int
+	)
+	err := s.db.QueryRowContext(r.Context(), `
+		SELECT current_database(), current_user, inet_server_addr()::text,
+		       inet_server_port(), pg_backend_pid(), version()
+	`).Scan(&database, &user, &serverAddr, &serverPort, &pid, &version)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{
+		"current_database": database,
+		"current_user":     user,
+		"server_addr":      nil,
+		"server_port":      nil,
+		"backend_pid":      pid,
+		"version":          version,
+	}
+	// inet_server_addr() is NULL over a Unix socket.
+	if serverAddr.Valid {
+		resp["server_addr"] = serverAddr.String
+	}
+	if serverPort.Valid {
+		resp["server_port"] = serverPort.Int64
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listUsers(w, r)
+	case http.MethodPost:
+		s.insertUser(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *server) handleUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/users/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "user id must be an integer")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getUser(w, r, id)
+	case http.MethodPut, http.MethodPatch:
+		s.updateUser(w, r, id)
+	case http.MethodDelete:
+		s.deleteUser(w, r, id)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+func (s *server) listUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.QueryContext(r.Context(), "SELECT id, name, created_at FROM app_users ORDER BY id")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	users := []appUser{}
+	for rows.Next() {
+		var u appUser
+		if err := rows.Scan(&u.ID, &u.Name, &u.CreatedAt); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(users), "users": users})
+}
+
+func (s *server) getUser(w http.ResponseWriter, r *http.Request, id int) {
+	var u appUser
+	err := s.db.QueryRowContext(r.Context(),
+		"SELECT id, name, created_at FROM app_users WHERE id = $1", id,
+	).Scan(&u.ID, &u.Name, &u.CreatedAt)
+	s.writeUser(w, http.StatusOK, u, id, err)
+}
+
+func (s *server) insertUser(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeUser(w, r)
+	if !ok {
+		return
+	}
+	var u appUser
+	err := s.db.QueryRowContext(r.Context(),
+		"INSERT INTO app_users (name) VALUES ($1) RETURNING id, name, created_at", req.Name,
+	).Scan(&u.ID, &u.Name, &u.CreatedAt)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Inserted user: ID %d, Name %s", u.ID, u.Name)
+	writeJSON(w, http.StatusCreated, u)
+}
+
+func (s *server) updateUser(w http.ResponseWriter, r *http.Request, id int) {
+	req, ok := decodeUser(w, r)
+	if !ok {
+		return
+	}
+	var u appUser
+	err := s.db.QueryRowContext(r.Context(),
+		"UPDATE app_users SET name = $1 WHERE id = $2 RETURNING id, name, created_at", req.Name, id,
+	).Scan(&u.ID, &u.Name, &u.CreatedAt)
+	if err == nil {
+		log.Printf("Updated user: ID %d, Name %s", u.ID, u.Name)
+	}
+	s.writeUser(w, http.StatusOK, u, id, err)
+}
+
+func (s *server) deleteUser(w http.ResponseWriter, r *http.Request, id int) {
+	var u appUser
+	err := s.db.QueryRowContext(r.Context(),
+		"DELETE FROM app_users WHERE id = $1 RETURNING id, name, created_at", id,
+	).Scan(&u.ID, &u.Name, &u.CreatedAt)
+	if err == nil {
+		log.Printf("Deleted user: ID %d, Name %s", u.ID, u.Name)
+	}
+	s.writeUser(w, http.StatusOK, u, id, err)
+}
+
+func (s *server) writeUser(w http.ResponseWriter, status int, u appUser, id int, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, fmt.Sprintf("user %d not found", id))
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, status, u)
+	}
+}
+
+func decodeUser(w http.ResponseWriter, r *http.Request) (userRequest, bool) {
+	var req userRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return req, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return req, false
+	}
+	if len(req.Name) > 255 {
+		writeError(w, http.StatusBadRequest, "name must be at most 255 characters")
+		return req, false
+	}
+	return req, true
+}
+
+type notifyRequest struct {
+	Channel string `json:"channel"`
+	Payload string `json:"payload"`
+}
+
+func (s *server) handleNotify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var req notifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Channel) == "" {
+		writeError(w, http.StatusBadRequest, "channel is required")
+		return
+	}
+	if _, err := s.db.ExecContext(r.Context(), "SELECT pg_notify($1, $2)", req.Channel, req.Payload); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "sent", "channel": req.Channel})
+}
+
+func (s *server) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if s.listen == nil {
+		writeError(w, http.StatusNotFound, "listen probe is off, set LISTEN_CHANNELS")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.listen.status())
+}
+
+func (s *server) handleReplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	if s.replication == nil {
+		writeError(w, http.StatusNotFound, "replication probe is off, set REPLICATION_SLOT")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.replication.status())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s (%s)", r.Method, r.URL.Path, time.Since(start).Round(time.Millisecond))
+	})
+}
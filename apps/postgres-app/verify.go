@@ -0,0 +1,358 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VERIFY_ISOLATION=1 turns the app into a one-shot branch check: it writes a
+// tagged row to the branch (DATABASE_URL), confirms the row is not visible in
+// the source (SOURCE_DATABASE_URL), compares per-table row counts and prints a
+// JSON report. The process exits 0 when every check passed and 1 otherwise.
+//
+// VERIFY_COUNTS decides how row counts are judged:
+//
+//	report  record counts only (default)
+//	match   every source table has the same count on the branch (copy mode all)
+//	empty   every source table is missing or empty on the branch (empty/schema)
+//
+// VERIFY_EXPECT_COUNTS=users=3,orders=4 pins exact branch counts for filtered
+// copies and takes precedence over VERIFY_COUNTS for the tables it names.
+
+type check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type tableCount struct {
+	Table    string `json:"table"`
+	Source   *int64 `json:"source"`
+	Branch   *int64 `json:"branch"`
+	Expected *int64 `json:"expected,omitempty"`
+	Passed   bool   `json:"passed"`
+}
+
+type isolationReport struct {
+	Engine     string            `json:"engine"`
+	Passed     bool              `json:"passed"`
+	Tag        string            `json:"tag"`
+	CountMode  string            `json:"count_mode"`
+	Branch     map[string]string `json:"branch"`
+	Source     map[string]string `json:"source"`
+	Checks     []check           `json:"checks"`
+	Tables     []tableCount      `json:"tables"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+func (r *isolationReport) add(name string, passed bool, detail string) {
+	r.Checks = append(r.Checks, check{Name: name, Passed: passed, Detail: detail})
+}
+
+func isVerifyMode() bool {
+	v := os.Getenv("VERIFY_ISOLATION")
+	return v == "1" || v == "true"
+}
+
+// runIsolationCheck runs the checks against an already connected branch and
+// returns the exit code.
+func runIsolationCheck(branch *sql.DB) int {
+	start := time.Now()
+	report := &isolationReport{
+		Engine:    "postgres",
+		Tag:       fmt.Sprintf("isolation-check-%d", time.Now().UnixNano()),
+		CountMode: os.Getenv("VERIFY_COUNTS"),
+		Tables:    []tableCount{},
+	}
+	if report.CountMode == "" {
+		report.CountMode = "report"
+	}
+
+	source, err := openSource()
+	if err != nil {
+		report.add("source_connect", false, err.Error())
+		return finishReport(report, start)
+	}
+	defer source.Close()
+	report.add("source_connect", true, "")
+
+	report.Branch = pgIdentity(branch)
+	report.Source = pgIdentity(source)
+	sameServer := report.Branch["server"] == report.Source["server"] &&
+		report.Branch["database"] == report.Source["database"]
+	report.add("distinct_databases", !sameServer,
+		fmt.Sprintf("branch=%s/%s source=%s/%s", report.Branch["server"], report.Branch["database"],
+			report.Source["server"], report.Source["database"]))
+
+	// Counts are taken before the tagged write so app_users is compared as
+	// the branch was handed to us.
+	compareCounts(report, source, branch)
+
+	if _, err := branch.Exec(`
+		CREATE TABLE IF NOT EXISTS app_users (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		report.add("branch_write", false, err.Error())
+		return finishReport(report, start)
+	}
+
+	var id int
+	if err := branch.QueryRow("INSERT INTO app_users (name) VALUES ($1) RETURNING id", report.Tag).Scan(&id); err != nil {
+		report.add("branch_write", false, err.Error())
+		return finishReport(report, start)
+	}
+	report.add("branch_write", true, fmt.Sprintf("app_users id=%d", id))
+
+	var onBranch int
+	if err := branch.QueryRow("SELECT COUNT(*) FROM app_users WHERE name = $1", report.Tag).Scan(&onBranch); err != nil {
+		report.add("branch_read_back", false, err.Error())
+	} else {
+		report.add("branch_read_back", onBranch == 1, fmt.Sprintf("%d row(s) on branch", onBranch))
+	}
+
+	// A source without app_users cannot hold the row either.
+	var onSource int
+	var exists bool
+	if err := source.QueryRow("SELECT to_regclass('app_users') IS NOT NULL").Scan(&exists); err != nil {
+		report.add("missing_from_source", false, err.Error())
+	} else if !exists {
+		report.add("missing_from_source", true, "app_users does not exist on source")
+	} else if err := source.QueryRow("SELECT COUNT(*) FROM app_users WHERE name = $1", report.Tag).Scan(&onSource); err != nil {
+		report.add("missing_from_source", false, err.Error())
+	} else {
+		report.add("missing_from_source", onSource == 0, fmt.Sprintf("%d row(s) on source", onSource))
+	}
+
+	if os.Getenv("VERIFY_KEEP_ROW") != "true" {
+		if _, err := branch.Exec("DELETE FROM app_users WHERE id = $1", id); err != nil {
+			log.Printf("Warning: failed to remove tagged row %d: %v", id, err)
+		}
+	}
+
+	return finishReport(report, start)
+}
+
+// openSource connects to SOURCE_DATABASE_URL with every transaction forced
+// read-only, so the check cannot modify the source by mistake.
+func openSource() (*sql.DB, error) {
+	sourceURL := os.Getenv("SOURCE_DATABASE_URL")
+	if sourceURL == "" {
+		return nil, fmt.Errorf("SOURCE_DATABASE_URL is not set")
+	}
+	sourceURL = withParam(sourceURL, "sslmode", "disable")
+	sourceURL = withParam(sourceURL, "default_transaction_read_only", "on")
+	log.Printf("Connecting to source database: %s", maskPassword(sourceURL))
+
+	db, err := sql.Open("postgres", sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func withParam(dbURL, key, value string) string {
+	if strings.Contains(dbURL, key+"=") {
+		return dbURL
+	}
+	if strings.Contains(dbURL, "?") {
+		return dbURL + "&" + key + "=" + value
+	}
+	return dbURL + "?" + key + "=" + value
+}
+
+func pgIdentity(db *sql.DB) map[string]string {
+	var database, addr, version string
+	var port, pid int
+	err := db.QueryRow(`
+		SELECT current_database(), COALESCE(inet_server_addr()::text, 'local'),
+		       COALESCE(inet_server_port(), 0), pg_backend_pid(), current_setting('server_version')
+	`).Scan(&database, &addr, &port, &pid, &version)
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	return map[string]string{
+		"database":    database,
+		"server":      fmt.Sprintf("%s:%d", addr, port),
+		"backend_pid": strconv.Itoa(pid),
+		"version":     version,
+	}
+}
+
+func compareCounts(report *isolationReport, source, branch *sql.DB) {
+	switch report.CountMode {
+	case "report", "match", "empty":
+	default:
+		report.add("row_counts", false, fmt.Sprintf("unknown VERIFY_COUNTS %q (want report, match or empty)", report.CountMode))
+		return
+	}
+
+	expected, err := parseExpectedCounts(os.Getenv("VERIFY_EXPECT_COUNTS"))
+	if err != nil {
+		report.add("row_counts", false, err.Error())
+		return
+	}
+
+	sourceCounts, err := tableCounts(source)
+	if err != nil {
+		report.add("row_counts", false, "source: "+err.Error())
+		return
+	}
+	branchCounts, err := tableCounts(branch)
+	if err != nil {
+		report.add("row_counts", false, "branch: "+err.Error())
+		return
+	}
+
+	failed := 0
+	for _, name := range sortedKeys(sourceCounts, branchCounts) {
+		tc := tableCount{Table: name, Passed: true}
+		if n, ok := sourceCounts[name]; ok {
+			tc.Source = &n
+		}
+		if n, ok := branchCounts[name]; ok {
+			tc.Branch = &n
+		}
+		branchRows := int64(0)
+		if tc.Branch != nil {
+			branchRows = *tc.Branch
+		}
+
+		if want, ok := lookupExpected(expected, name); ok {
+			tc.Expected = &want
+			tc.Passed = tc.Branch != nil && *tc.Branch == want
+		} else if tc.Source != nil {
+			switch report.CountMode {
+			case "match":
+				tc.Passed = tc.Branch != nil && *tc.Branch == *tc.Source
+			case "empty":
+				tc.Passed = branchRows == 0
+			}
+		}
+		if !tc.Passed {
+			failed++
+		}
+		report.Tables = append(report.Tables, tc)
+	}
+	report.add("row_counts", failed == 0, fmt.Sprintf("mode=%s, %d table(s), %d mismatched", report.CountMode, len(report.Tables), failed))
+}
+
+func tableCounts(db *sql.DB) (map[string]int64, error) {
+	rows, err := db.Query(`
+		SELECT table_schema, table_name,
+		       quote_ident(table_schema) || '.' || quote_ident(table_name)
+		FROM information_schema.tables
+		WHERE table_type = 'BASE TABLE'
+		  AND table_schema NOT IN ('pg_catalog', 'information_schema')
+		ORDER BY 1, 2
+	`)
+	if err != nil {
+		return nil, err
+	}
+	type table struct{ name, quoted string }
+	var tables []table
+	for rows.Next() {
+		var schema, name, quoted string
+		if err := rows.Scan(&schema, &name, &quoted); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, table{name: schema + "." + name, quoted: quoted})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(tables))
+	for _, t := range tables {
+		var n int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + t.quoted).Scan(&n); err != nil {
+			return nil, fmt.Errorf("count %s: %w", t.name, err)
+		}
+		counts[t.name] = n
+	}
+	return counts, nil
+}
+
+func parseExpectedCounts(spec string) (map[string]int64, error) {
+	expected := map[string]int64{}
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("VERIFY_EXPECT_COUNTS entry %q must be table=count", kv)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("VERIFY_EXPECT_COUNTS entry %q: %w", kv, err)
+		}
+		expected[strings.TrimSpace(parts[0])] = n
+	}
+	return expected, nil
+}
+
+// lookupExpected matches either the qualified name (public.users) or the bare
+// table name (users).
+func lookupExpected(expected map[string]int64, table string) (int64, bool) {
+	if n, ok := expected[table]; ok {
+		return n, true
+	}
+	n, ok := expected[table[strings.LastIndex(table, ".")+1:]]
+	return n, ok
+}
+
+func sortedKeys(a, b map[string]int64) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]int64{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func finishReport(report *isolationReport, start time.Time) int {
+	report.Passed = true
+	for _, c := range report.Checks {
+		if !c.Passed {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Isolation check FAILED")
+		return 1
+	}
+	log.Println("Isolation check passed")
+	return 0
+}
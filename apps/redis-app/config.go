@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisClient builds the client from the environment. The connection
+// source is picked in this order:
+//
+//	REDIS_CLUSTER_ADDRS   comma-separated cluster seed addresses
+//	REDIS_SENTINEL_ADDRS  comma-separated sentinels (+ REDIS_SENTINEL_MASTER)
+//	REDIS_URL             redis://[user:pass@]host:port[/db], rediss:// for TLS
+//	REDIS_ADDR            plain host:port (what db_branches patches)
+//
+// REDIS_USERNAME, REDIS_PASSWORD, REDIS_DB and the REDIS_TLS_* variables
+// override whatever the URL carried and apply to every source. Redis Cluster
+// only has DB 0, so a non-zero DB with REDIS_CLUSTER_ADDRS is an error rather
+// than being dropped.
+func newRedisClient() (redis.UniversalClient, error) {
+	opts, source, err := baseOptions()
+	if err != nil {
+		return nil, err
+	}
+	if err := applyOverrides(opts); err != nil {
+		return nil, err
+	}
+
+	if seeds := splitAddrs(os.Getenv("REDIS_CLUSTER_ADDRS")); len(seeds) > 0 {
+		if opts.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports DB 0, but DB %d is set (REDIS_DB or the /db path of REDIS_URL)", opts.DB)
+		}
+		log.Printf("Connecting to Redis Cluster via seeds %s (%s)", strings.Join(seeds, ","), describe(opts))
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     seeds,
+			Username:  opts.Username,
+			Password:  opts.Password,
+			TLSConfig: opts.TLSConfig,
+		}), nil
+	}
+
+	if sentinels := splitAddrs(os.Getenv("REDIS_SENTINEL_ADDRS")); len(sentinels) > 0 {
+		master := getEnv("REDIS_SENTINEL_MASTER", "mymaster")
+		log.Printf("Connecting to Redis via Sentinel %s master=%s (%s)", strings.Join(sentinels, ","), master, describe(opts))
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       master,
+			SentinelAddrs:    sentinels,
+			SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+			SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+			Username:         opts.Username,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			TLSConfig:        opts.TLSConfig,
+		}), nil
+	}
+
+	log.Printf("Connecting to Redis at %s from %s (%s)", opts.Addr, source, describe(opts))
+	return redis.NewClient(opts), nil
+}
+
+func baseOptions() (*redis.Options, string, error) {
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse REDIS_URL: %w", err)
+		}
+		return opts, "REDIS_URL", nil
+	}
+	return &redis.Options{Addr: getEnv("REDIS_ADDR", "redis-main:6379")}, "REDIS_ADDR", nil
+}
+
+func applyOverrides(opts *redis.Options) error {
+	if v := os.Getenv("REDIS_USERNAME"); v != "" {
+		opts.Username = v
+	}
+	if v := os.Getenv("REDIS_PASSWORD"); v != "" {
+		opts.Password = v
+	}
+	if v := os.Getenv("REDIS_DB"); v != "" {
+		db, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("REDIS_DB must be an integer: %w", err)
+		}
+		opts.DB = db
+	}
+
+	tlsConfig, err := tlsFromEnv(opts.TLSConfig)
+	if err != nil {
+		return err
+	}
+	opts.TLSConfig = tlsConfig
+	return nil
+}
+
+// tlsFromEnv returns the TLS config to use. TLS is on when REDIS_TLS=true or
+// the URL was rediss://; a custom CA, client certificate, SNI name and
+// verification skip can each be set on top.
+func tlsFromEnv(fromURL *tls.Config) (*tls.Config, error) {
+	enabled := fromURL != nil || os.Getenv("REDIS_TLS") == "true"
+	if !enabled {
+		return nil, nil
+	}
+
+	cfg := fromURL
+	if cfg == nil {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if caFile := os.Getenv("REDIS_TLS_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read REDIS_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	certFile, keyFile := os.Getenv("REDIS_TLS_CERT_FILE"), os.Getenv("REDIS_TLS_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if v := os.Getenv("REDIS_TLS_SERVER_NAME"); v != "" {
+		cfg.ServerName = v
+	}
+	if os.Getenv("REDIS_TLS_INSECURE") == "true" {
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
+
+// describe summarises auth and TLS settings for the startup log without
+// printing the password.
+func describe(opts *redis.Options) string {
+	parts := []string{fmt.Sprintf("db=%d", opts.DB)}
+	switch {
+	case opts.Username != "" && opts.Password != "":
+		parts = append(parts, fmt.Sprintf("auth=acl user=%s password=****", opts.Username))
+	case opts.Password != "":
+		parts = append(parts, "auth=password password=****")
+	default:
+		parts = append(parts, "auth=none")
+	}
+	if opts.TLSConfig == nil {
+		parts = append(parts, "tls=off")
+	} else {
+		tlsDesc := "tls=on"
+		if os.Getenv("REDIS_TLS_CA_FILE") != "" {
+			tlsDesc += " ca=" + os.Getenv("REDIS_TLS_CA_FILE")
+		}
+		if opts.TLSConfig.InsecureSkipVerify {
+			tlsDesc += " verify=off"
+		}
+		parts = append(parts, tlsDesc)
+	}
+	return strings.Join(parts, " ")
+}
+
+func splitAddrs(csv string) []string {
+	var addrs []string
+	for _, a := range strings.Split(csv, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -2,30 +2,30 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-var rdb *redis.Client
+var rdb redis.UniversalClient
 var ctx = context.Background()
 
 func main() {
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "redis-main:6379"
+	var err error
+	rdb, err = newRedisClient()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	log.Printf("Connecting to Redis at %s", redisAddr)
-
-	rdb = redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
-
 	// Test connection
 	if err := rdb.Ping(ctx).Err(); err != nil {
 		log.Printf("Warning: Redis ping failed: %v", err)
@@ -34,10 +34,16 @@ func main() {
 	}
 
 	http.HandleFunc("/", handleRoot)
-	http.HandleFunc("/get", handleGet)
-	http.HandleFunc("/set", handleSet)
-	http.HandleFunc("/keys", handleKeys)
 	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/keys", handleKeys)
+	http.HandleFunc("/value", handleValue)
+	http.HandleFunc("/ttl", handleTTL)
+	http.HandleFunc("/strings", handleStrings)
+	http.HandleFunc("/hashes", handleHashes)
+	http.HandleFunc("/lists", handleLists)
+	http.HandleFunc("/sets", handleSets)
+	http.HandleFunc("/zsets", handleZSets)
+	http.HandleFunc("/watch", handleWatch)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -49,80 +55,758 @@ func main() {
 }
 
 func handleRoot(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "Redis Test App\n\n")
-	fmt.Fprintf(w, "Endpoints:\n")
-	fmt.Fprintf(w, "  GET /get?key=<key>     - Get value\n")
-	fmt.Fprintf(w, "  GET /set?key=<k>&val=<v> - Set value\n")
-	fmt.Fprintf(w, "  GET /keys              - List all keys\n")
-	fmt.Fprintf(w, "  GET /health            - Health check\n")
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint: %s", r.URL.Path))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"app": "redis-app",
+		"endpoints": []string{
+			"GET    /health",
+			"GET    /keys?match=<glob>&type=<type>&limit=<n>   SCAN with type and TTL per key",
+			"GET    /value?key=<k>                             read any type",
+			"DELETE /value?key=<k>",
+			"GET    /ttl?key=<k>",
+			"POST   /ttl      {\"key\",\"ttl_seconds\"}          ttl_seconds <= 0 persists",
+			"POST   /strings  {\"key\",\"value\",\"ttl_seconds\"}",
+			"POST   /hashes   {\"key\",\"fields\":{...},\"ttl_seconds\"}",
+			"POST   /lists    {\"key\",\"values\":[...],\"left\",\"ttl_seconds\"}",
+			"POST   /sets     {\"key\",\"members\":[...],\"ttl_seconds\"}",
+			"POST   /zsets    {\"key\",\"members\":[{\"member\",\"score\"}],\"ttl_seconds\"}",
+			"GET    /watch?pattern=<glob>&seconds=<n>&configure=true   keyspace events as NDJSON",
+		},
+	})
 }
 
-func handleGet(w http.ResponseWriter, r *http.Request) {
-	key := r.URL.Query().Get("key")
-	if key == "" {
-		http.Error(w, "key parameter required", http.StatusBadRequest)
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	err := rdb.Ping(r.Context()).Err()
+	latency := time.Since(start)
+
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unhealthy",
+			"error":  err.Error(),
+		})
 		return
 	}
 
-	val, err := rdb.Get(ctx, key).Result()
-	if err == redis.Nil {
-		fmt.Fprintf(w, "Key '%s' not found\n", key)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "healthy",
+		"latency_ms": float64(latency.Microseconds()) / 1000,
+	})
+}
+
+// keyInfo is one entry of the /keys listing. TTLMillis is -1 for keys without
+// an expiry.
+type keyInfo struct {
+	Key       string `json:"key"`
+	Type      string `json:"type"`
+	TTLMillis int64  `json:"ttl_ms"`
+}
+
+// handleKeys lists keys with SCAN instead of KEYS so it does not block the
+// server on large keyspaces. Types and TTLs are fetched in one pipeline per
+// batch. On a cluster every master is scanned.
+func handleKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	match := q.Get("match")
+	if match == "" {
+		match = "*"
+	}
+	keyType := q.Get("type")
+	limit, err := queryInt(q.Get("limit"), 1000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
-	} else if err != nil {
-		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+	}
+
+	// Every node is scanned up to limit on its own; the keys are merged, sorted
+	// and only then cut to limit, so a truncated listing on a cluster no longer
+	// depends on which master answered first.
+	var mu sync.Mutex
+	var keys []keyInfo
+	truncated := false
+	scanNode := func(ctx context.Context, node redis.UniversalClient) error {
+		var nodeKeys []keyInfo
+		var cursor uint64
+		for {
+			var batch []string
+			var err error
+			if keyType != "" {
+				batch, cursor, err = node.ScanType(ctx, cursor, match, 200, keyType).Result()
+			} else {
+				batch, cursor, err = node.Scan(ctx, cursor, match, 200).Result()
+			}
+			if err != nil {
+				return err
+			}
+
+			infos, err := describeKeys(ctx, node, batch)
+			if err != nil {
+				return err
+			}
+			nodeKeys = append(nodeKeys, infos...)
+			if len(nodeKeys) >= limit || cursor == 0 {
+				break
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, nodeKeys...)
+		if cursor != 0 {
+			truncated = true
+		}
+		return nil
+	}
+
+	if cluster, ok := rdb.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(r.Context(), func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node)
+		})
+	} else {
+		err = scanNode(r.Context(), rdb)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	fmt.Fprintf(w, "%s = %s\n", key, val)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	if len(keys) > limit {
+		keys = keys[:limit]
+		truncated = true
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"match":     match,
+		"count":     len(keys),
+		"truncated": truncated,
+		"keys":      keys,
+	})
 }
 
-func handleSet(w http.ResponseWriter, r *http.Request) {
+func describeKeys(ctx context.Context, node redis.UniversalClient, keys []string) ([]keyInfo, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	pipe := node.Pipeline()
+	types := make([]*redis.StatusCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, k := range keys {
+		types[i] = pipe.Type(ctx, k)
+		ttls[i] = pipe.PTTL(ctx, k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	infos := make([]keyInfo, 0, len(keys))
+	for i, k := range keys {
+		t := types[i].Val()
+		if t == "none" {
+			// Expired or deleted between SCAN and TYPE.
+			continue
+		}
+		infos = append(infos, keyInfo{Key: k, Type: t, TTLMillis: ttlMillis(ttls[i].Val())})
+	}
+	return infos, nil
+}
+
+// handleValue reads or deletes a key of any type.
+func handleValue(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
-	val := r.URL.Query().Get("val")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("key parameter required"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		readValue(w, r, key)
+	case http.MethodDelete:
+		n, err := rdb.Del(r.Context(), key).Result()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "deleted": n == 1})
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func readValue(w http.ResponseWriter, r *http.Request, key string) {
+	ctx := r.Context()
+	limit, err := queryInt(r.URL.Query().Get("limit"), 1000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	keyType, err := rdb.Type(ctx, key).Result()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if keyType == "none" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("key %q not found", key))
+		return
+	}
 
-	if key == "" || val == "" {
-		http.Error(w, "key and val parameters required", http.StatusBadRequest)
+	var value interface{}
+	var truncated bool
+	switch keyType {
+	case "string":
+		value, err = rdb.Get(ctx, key).Result()
+	case "hash":
+		value, err = rdb.HGetAll(ctx, key).Result()
+	case "list":
+		value, err = rdb.LRange(ctx, key, 0, int64(limit-1)).Result()
+		if err == nil {
+			truncated, err = overLimit(rdb.LLen(ctx, key), limit)
+		}
+	case "set":
+		var members []string
+		members, truncated, err = scanSet(ctx, key, limit)
+		value = members
+	case "zset":
+		var zs []redis.Z
+		zs, err = rdb.ZRangeWithScores(ctx, key, 0, int64(limit-1)).Result()
+		members := make([]map[string]interface{}, len(zs))
+		for i, z := range zs {
+			members[i] = map[string]interface{}{"member": z.Member, "score": z.Score}
+		}
+		value = members
+		if err == nil {
+			truncated, err = overLimit(rdb.ZCard(ctx, key), limit)
+		}
+	case "stream":
+		value, err = rdb.XRangeN(ctx, key, "-", "+", int64(limit)).Result()
+		if err == nil {
+			truncated, err = overLimit(rdb.XLen(ctx, key), limit)
+		}
+	default:
+		err = fmt.Errorf("unsupported type %q", keyType)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	err := rdb.Set(ctx, key, val, 0).Err()
+	ttl, err := rdb.PTTL(ctx, key).Result()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	fmt.Fprintf(w, "Set %s = %s\n", key, val)
+	resp := map[string]interface{}{
+		"key":    key,
+		"type":   keyType,
+		"ttl_ms": ttlMillis(ttl),
+		"value":  value,
+	}
+	switch keyType {
+	case "list", "set", "zset", "stream":
+		resp["truncated"] = truncated
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
-func handleKeys(w http.ResponseWriter, r *http.Request) {
-	keys, err := rdb.Keys(ctx, "*").Result()
+// overLimit reports whether a collection whose length cmd returns holds more
+// than the limit readValue returned.
+func overLimit(cmd *redis.IntCmd, limit int) (bool, error) {
+	n, err := cmd.Result()
+	return n > int64(limit), err
+}
+
+// scanSet reads up to limit members of a set. COUNT is only a hint, so one
+// SSCAN page may hold fewer members (or none) while the cursor is not done;
+// it keeps scanning until the cursor is 0 or limit members are in. SSCAN may
+// return a member twice, so members are deduplicated.
+func scanSet(ctx context.Context, key string, limit int) ([]string, bool, error) {
+	seen := map[string]bool{}
+	members := []string{}
+	var cursor uint64
+	for {
+		batch, next, err := rdb.SScan(ctx, key, cursor, "*", int64(limit)).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, m := range batch {
+			if !seen[m] {
+				seen[m] = true
+				members = append(members, m)
+			}
+		}
+		cursor = next
+		if len(members) >= limit || cursor == 0 {
+			break
+		}
+	}
+	truncated := cursor != 0 || len(members) > limit
+	if len(members) > limit {
+		members = members[:limit]
+	}
+	sort.Strings(members)
+	return members, truncated, nil
+}
+
+type ttlRequest struct {
+	Key        string `json:"key"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+// handleTTL reads the TTL of a key (GET) or sets it (POST). A non-positive
+// ttl_seconds removes the expiry.
+func handleTTL(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("key parameter required"))
+			return
+		}
+		ttl, err := rdb.PTTL(r.Context(), key).Result()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if ttl == -2 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("key %q not found", key))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "ttl_ms": ttlMillis(ttl)})
+
+	case http.MethodPost:
+		var req ttlRequest
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		var ok bool
+		var err error
+		if req.TTLSeconds > 0 {
+			ok, err = rdb.Expire(r.Context(), req.Key, time.Duration(req.TTLSeconds)*time.Second).Result()
+		} else {
+			ok, err = rdb.Persist(r.Context(), req.Key).Result()
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": req.Key, "ttl_seconds": req.TTLSeconds, "updated": ok})
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+type stringRequest struct {
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+func handleStrings(w http.ResponseWriter, r *http.Request) {
+	var req stringRequest
+	if !requirePost(w, r) || !decodeBody(w, r, &req) {
+		return
+	}
+	err := rdb.Set(r.Context(), req.Key, req.Value, time.Duration(req.TTLSeconds)*time.Second).Err()
+	writeResult(w, req.Key, "string", err)
+}
+
+type hashRequest struct {
+	Key        string            `json:"key"`
+	Fields     map[string]string `json:"fields"`
+	TTLSeconds int64             `json:"ttl_seconds"`
+}
+
+func handleHashes(w http.ResponseWriter, r *http.Request) {
+	var req hashRequest
+	if !requirePost(w, r) || !decodeBody(w, r, &req) {
+		return
+	}
+	if len(req.Fields) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("fields must not be empty"))
+		return
+	}
+	err := writeWithTTL(r.Context(), req.Key, req.TTLSeconds, func(pipe redis.Pipeliner) {
+		pipe.HSet(r.Context(), req.Key, req.Fields)
+	})
+	writeResult(w, req.Key, "hash", err)
+}
+
+type listRequest struct {
+	Key        string   `json:"key"`
+	Values     []string `json:"values"`
+	Left       bool     `json:"left"`
+	TTLSeconds int64    `json:"ttl_seconds"`
+}
+
+func handleLists(w http.ResponseWriter, r *http.Request) {
+	var req listRequest
+	if !requirePost(w, r) || !decodeBody(w, r, &req) {
+		return
+	}
+	if len(req.Values) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("values must not be empty"))
+		return
+	}
+	err := writeWithTTL(r.Context(), req.Key, req.TTLSeconds, func(pipe redis.Pipeliner) {
+		if req.Left {
+			pipe.LPush(r.Context(), req.Key, toArgs(req.Values)...)
+		} else {
+			pipe.RPush(r.Context(), req.Key, toArgs(req.Values)...)
+		}
+	})
+	writeResult(w, req.Key, "list", err)
+}
+
+type setRequest struct {
+	Key        string   `json:"key"`
+	Members    []string `json:"members"`
+	TTLSeconds int64    `json:"ttl_seconds"`
+}
+
+func handleSets(w http.ResponseWriter, r *http.Request) {
+	var req setRequest
+	if !requirePost(w, r) || !decodeBody(w, r, &req) {
+		return
+	}
+	if len(req.Members) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("members must not be empty"))
+		return
+	}
+	err := writeWithTTL(r.Context(), req.Key, req.TTLSeconds, func(pipe redis.Pipeliner) {
+		pipe.SAdd(r.Context(), req.Key, toArgs(req.Members)...)
+	})
+	writeResult(w, req.Key, "set", err)
+}
+
+type zsetRequest struct {
+	Key     string `json:"key"`
+	Members []struct {
+		Member string  `json:"member"`
+		Score  float64 `json:"score"`
+	} `json:"members"`
+	TTLSeconds int64 `json:"ttl_seconds"`
+}
+
+func handleZSets(w http.ResponseWriter, r *http.Request) {
+	var req zsetRequest
+	if !requirePost(w, r) || !decodeBody(w, r, &req) {
+		return
+	}
+	if len(req.Members) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("members must not be empty"))
+		return
+	}
+	members := make([]redis.Z, len(req.Members))
+	for i, m := range req.Members {
+		members[i] = redis.Z{Member: m.Member, Score: m.Score}
+	}
+	err := writeWithTTL(r.Context(), req.Key, req.TTLSeconds, func(pipe redis.Pipeliner) {
+		pipe.ZAdd(r.Context(), req.Key, members...)
+	})
+	writeResult(w, req.Key, "zset", err)
+}
+
+// writeWithTTL runs a write and the optional EXPIRE in one MULTI/EXEC so the
+// key never exists without its TTL.
+func writeWithTTL(ctx context.Context, key string, ttlSeconds int64, write func(redis.Pipeliner)) error {
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		write(pipe)
+		if ttlSeconds > 0 {
+			pipe.Expire(ctx, key, time.Duration(ttlSeconds)*time.Second)
+		}
+		return nil
+	})
+	return err
+}
+
+// handleWatch streams keyspace notifications as newline-delimited JSON until
+// the client disconnects or `seconds` elapse. With configure=true it first
+// enables notifications (notify-keyspace-events KEA) on every node; otherwise
+// the server config is left alone and nothing arrives if it is off.
+func handleWatch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pattern := q.Get("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+	seconds, err := queryInt(q.Get("seconds"), 30)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Redis error: %v", err), http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
 		return
 	}
 
-	if len(keys) == 0 {
-		fmt.Fprintf(w, "No keys found\n")
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(seconds)*time.Second)
+	defer cancel()
+
+	nodes, err := nodeClients(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	fmt.Fprintf(w, "Keys (%d):\n", len(keys))
-	for _, key := range keys {
-		val, _ := rdb.Get(ctx, key).Result()
-		fmt.Fprintf(w, "  %s = %s\n", key, val)
+	if q.Get("configure") == "true" {
+		for _, node := range nodes {
+			if err := node.ConfigSet(ctx, "notify-keyspace-events", "KEA").Err(); err != nil {
+				writeError(w, http.StatusInternalServerError, fmt.Errorf("CONFIG SET on %s: %w", node.Options().Addr, err))
+				return
+			}
+		}
+	}
+
+	events := make(chan map[string]interface{})
+	for _, node := range nodes {
+		pubsub := node.PSubscribe(ctx, watchChannels(ctx, node, pattern)...)
+		defer pubsub.Close()
+		if _, err := pubsub.Receive(ctx); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("PSUBSCRIBE on %s: %w", node.Options().Addr, err))
+			return
+		}
+
+		addr := node.Options().Addr
+		go func() {
+			for msg := range pubsub.Channel() {
+				event := map[string]interface{}{
+					"time":    time.Now().Format(time.RFC3339Nano),
+					"node":    addr,
+					"channel": msg.Channel,
+				}
+				if strings.HasPrefix(msg.Channel, "__keyspace@") {
+					event["key"] = msg.Channel[strings.Index(msg.Channel, ":")+1:]
+					event["event"] = msg.Payload
+				} else {
+					event["event"] = msg.Channel[strings.Index(msg.Channel, ":")+1:]
+					event["key"] = msg.Payload
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			// keyevent messages arrive for every key; apply the key
+			// pattern here so either form is filtered the same way.
+			if !globMatch(pattern, event["key"].(string)) {
+				continue
+			}
+			if err := enc.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
 	}
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	err := rdb.Ping(ctx).Err()
-	latency := time.Since(start)
+// watchChannels picks one notification form per node, since with both
+// enabled (KEA, what configure=true sets) every change is published twice.
+// Keyspace channels look like __keyspace@0__:<key> with the event as payload
+// and are preferred; keyevent channels, __keyevent@0__:<event> with the key,
+// are used only when the node publishes keyevent alone. When the flags cannot
+// be read (CONFIG disabled), keyspace is assumed.
+func watchChannels(ctx context.Context, node *redis.Client, pattern string) []string {
+	keyspace := []string{"__keyspace@*__:" + pattern}
+	flags, err := node.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		log.Printf("watch: cannot read notify-keyspace-events on %s, assuming keyspace events: %v", node.Options().Addr, err)
+		return keyspace
+	}
+	value := flags["notify-keyspace-events"]
+	if !strings.Contains(value, "K") && strings.Contains(value, "E") {
+		return []string{"__keyevent@*__:*"}
+	}
+	return keyspace
+}
+
+// nodeClients returns a client per master: the single client, or one per
+// cluster master since keyspace events are only published on the node that
+// owns the key.
+func nodeClients(ctx context.Context) ([]*redis.Client, error) {
+	cluster, ok := rdb.(*redis.ClusterClient)
+	if !ok {
+		return []*redis.Client{rdb.(*redis.Client)}, nil
+	}
+	var mu sync.Mutex
+	var nodes []*redis.Client
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		mu.Lock()
+		nodes = append(nodes, node)
+		mu.Unlock()
+		return nil
+	})
+	return nodes, err
+}
+
+// globMatch applies a Redis glob to a key with the server's own MATCH rules
+// (stringmatchlen): * and ? wildcards, [abc] classes with a-z ranges (either
+// order) and ^ negation, and \ escapes, inside classes too. As in Redis, ! is
+// not a negation and an unterminated [ class runs to the end of the pattern.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], s[0])
+			if !matched {
+				return false
+			}
+			pattern = rest
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the class that starts at class (just past the
+// [) and returns the pattern after the closing ].
+func matchClass(class string, c byte) (bool, string) {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+	matched := false
+	for {
+		switch {
+		case len(class) == 0:
+			return matched != negate, ""
+		case class[0] == '\\' && len(class) >= 2:
+			if class[1] == c {
+				matched = true
+			}
+			class = class[2:]
+		case class[0] == ']':
+			return matched != negate, class[1:]
+		case len(class) >= 3 && class[1] == '-':
+			lo, hi := class[0], class[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			class = class[3:]
+		default:
+			if class[0] == c {
+				matched = true
+			}
+			class = class[1:]
+		}
+	}
+}
 
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return false
+	}
+	return true
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeResult(w http.ResponseWriter, key, keyType string, err error) {
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		fmt.Fprintf(w, "unhealthy: %v\n", err)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	log.Printf("Wrote %s %s", keyType, key)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "type": keyType, "ok": true})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]interface{}{"error": err.Error()})
+}
 
-	fmt.Fprintf(w, "healthy (redis latency: %v)\n", latency)
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed (use %s)", strings.Join(allowed, " or ")))
+}
+
+func queryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected a positive integer, got %q", value)
+	}
+	return n, nil
+}
+
+// ttlMillis maps PTTL results to milliseconds, keeping Redis' -1 (no expiry)
+// and -2 (missing key) sentinels. go-redis returns those as raw durations.
+func ttlMillis(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return int64(ttl)
+	}
+	return ttl.Milliseconds()
+}
+
+func toArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
 }
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+// TestGlobMatch pins globMatch to Redis's own MATCH rules (stringmatchlen),
+// since it decides which /watch events are streamed.
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"*", "user:1", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"*:1", "user:1", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+
+		{"?", "", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+
+		{"user:[a-c]", "user:b", true},
+		{"user:[a-c]", "user:d", false},
+		{"user:[a-c]", "user:-", false},
+		{"user:[c-a]", "user:b", true},
+		{"k[0-9][0-9]", "k42", true},
+		{"k[abc]", "kc", true},
+		{"k[abc]", "kd", false},
+
+		{"k[^x]", "ky", true},
+		{"k[^x]", "kx", false},
+		{"k[^0-9]", "k5", false},
+		{"k[^0-9]", "ka", true},
+		{"k[!x]", "k!", true},
+		{"k[!x]", "ky", false},
+
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{`a\?`, "a?", true},
+		{`k[\]]`, "k]", true},
+		{`k[\-]`, "k-", true},
+
+		{"k[abc", "kb", true},
+		{"k[abc", "kbx", false},
+		{"k[", "k", false},
+		{"k[]", "k]", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -2,29 +2,58 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-func main() {
-	channel := os.Getenv("REDIS_CHANNEL")
-	if channel == "" {
-		log.Fatal("REDIS_CHANNEL must be set")
-	}
+const (
+	minBackoff    = time.Second
+	maxBackoff    = 30 * time.Second
+	healthTimeout = 30 * time.Second
+)
 
+// channelConfig is one entry of REDIS_CHANNEL. index is the 1-based position
+// in the CSV and is used as the output prefix, so `2:<payload>` means the
+// message arrived on the second configured channel.
+type channelConfig struct {
+	index int
+	name  string
+	mode  string
+}
+
+func main() {
 	redisURL := os.Getenv("REDIS_URL")
 	if redisURL == "" {
 		redisURL = "redis://redis-main.redis-test.svc.cluster.local:6379"
 	}
 
-	subscribeMode := os.Getenv("REDIS_SUBSCRIBE_MODE")
-	if subscribeMode == "" {
-		subscribeMode = "exact"
+	// REDIS_CHANNEL_ENV_VARS lists the env vars holding channels, so a split
+	// config can patch each channel through its own variable. The values are
+	// concatenated in order and numbered as one list.
+	channelEnvVars := os.Getenv("REDIS_CHANNEL_ENV_VARS")
+	if channelEnvVars == "" {
+		channelEnvVars = "REDIS_CHANNEL"
+	}
+	var channelValues []string
+	for _, env := range strings.Split(channelEnvVars, ",") {
+		if v := os.Getenv(strings.TrimSpace(env)); v != "" {
+			channelValues = append(channelValues, v)
+		}
+	}
+
+	channels, err := collectChannels(strings.Join(channelValues, ","), os.Getenv("REDIS_SUBSCRIBE_MODE"))
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	opts, err := redis.ParseURL(redisURL)
@@ -35,34 +64,213 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	client := redis.NewClient(opts)
+	client := newClient(opts, os.Getenv("REDIS_CLUSTER_ADDRS"))
 	defer client.Close()
 
-	fmt.Fprintf(os.Stderr, "redis-pubsub-consumer starting channel=%s url=%s mode=%s\n", channel, redisURL, subscribeMode)
-
-	var pubsub *redis.PubSub
-	switch subscribeMode {
-	case "pattern":
-		pubsub = client.PSubscribe(ctx, channel)
-	case "sharded":
-		pubsub = client.SSubscribe(ctx, channel)
-	default:
-		pubsub = client.Subscribe(ctx, channel)
+	if os.Getenv("PUBLISH_MODE") == "true" {
+		publish(ctx, client, channels)
+		return
 	}
-	defer pubsub.Close()
 
-	if _, err := pubsub.Receive(ctx); err != nil {
-		log.Fatalf("Subscribe failed: %v", err)
+	names := make([]string, len(channels))
+	modes := make([]string, len(channels))
+	for i, ch := range channels {
+		names[i] = ch.name
+		modes[i] = ch.mode
+	}
+	fmt.Fprintf(os.Stderr, "redis-pubsub-consumer starting channel=%s url=%s mode=%s\n",
+		strings.Join(names, ","), redisURL, strings.Join(modes, ","))
+	if _, ok := client.(*redis.ClusterClient); ok {
+		fmt.Fprintf(os.Stderr, "redis-pubsub-consumer cluster seeds=%s\n", os.Getenv("REDIS_CLUSTER_ADDRS"))
 	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigChan
+		fmt.Fprintln(os.Stderr, "Received shutdown signal")
 		cancel()
 	}()
 
-	for msg := range pubsub.Channel() {
-		fmt.Printf("1:%s\n", msg.Payload)
+	var wg sync.WaitGroup
+	for _, ch := range channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consume(ctx, client, ch)
+		}()
+	}
+	wg.Wait()
+}
+
+// newClient returns a cluster client when REDIS_CLUSTER_ADDRS lists seed
+// addresses, and a single-node client otherwise. Credentials and TLS from
+// REDIS_URL apply to every cluster node.
+func newClient(opts *redis.Options, clusterAddrs string) redis.UniversalClient {
+	var seeds []string
+	for _, a := range strings.Split(clusterAddrs, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			seeds = append(seeds, a)
+		}
+	}
+	if len(seeds) == 0 {
+		return redis.NewClient(opts)
+	}
+	return redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:     seeds,
+		Username:  opts.Username,
+		Password:  opts.Password,
+		TLSConfig: opts.TLSConfig,
+	})
+}
+
+// collectChannels parses REDIS_CHANNEL as a CSV of channels. REDIS_SUBSCRIBE_MODE
+// is either a single mode applied to every channel or a CSV of the same length
+// giving the mode for each channel by position.
+func collectChannels(channelCSV, modeCSV string) ([]channelConfig, error) {
+	var names []string
+	for _, c := range strings.Split(channelCSV, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			names = append(names, c)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("REDIS_CHANNEL (or the vars in REDIS_CHANNEL_ENV_VARS) must be set")
+	}
+
+	var modes []string
+	for _, m := range strings.Split(modeCSV, ",") {
+		modes = append(modes, strings.TrimSpace(m))
+	}
+	if len(modes) != 1 && len(modes) != len(names) {
+		return nil, fmt.Errorf("REDIS_SUBSCRIBE_MODE has %d entries but REDIS_CHANNEL has %d", len(modes), len(names))
+	}
+
+	channels := make([]channelConfig, len(names))
+	for i, name := range names {
+		mode := modes[0]
+		if len(modes) > 1 {
+			mode = modes[i]
+		}
+		if mode == "" {
+			mode = "exact"
+		}
+		switch mode {
+		case "exact", "pattern", "sharded":
+		default:
+			return nil, fmt.Errorf("unknown subscribe mode %q for channel %s (want exact, pattern or sharded)", mode, name)
+		}
+		channels[i] = channelConfig{index: i + 1, name: name, mode: mode}
+	}
+	return channels, nil
+}
+
+func subscribe(ctx context.Context, client redis.UniversalClient, ch channelConfig) *redis.PubSub {
+	switch ch.mode {
+	case "pattern":
+		return client.PSubscribe(ctx, ch.name)
+	case "sharded":
+		return client.SSubscribe(ctx, ch.name)
+	default:
+		return client.Subscribe(ctx, ch.name)
+	}
+}
+
+// consume keeps a subscription to one channel alive until ctx is cancelled.
+// Operator upgrades and session restarts drop the Redis connection, so any
+// receive error tears the subscription down and resubscribes with backoff.
+func consume(ctx context.Context, client redis.UniversalClient, ch channelConfig) {
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		received, err := receive(ctx, client, ch)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = minBackoff
+		}
+		fmt.Fprintf(os.Stderr, "[%d] subscription to %s lost: %v (resubscribing in %s)\n", ch.index, ch.name, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// receive runs a single subscription until it fails. It reports whether the
+// subscription was confirmed, so the caller can reset its backoff.
+func receive(ctx context.Context, client redis.UniversalClient, ch channelConfig) (bool, error) {
+	pubsub := subscribe(ctx, client, ch)
+	defer pubsub.Close()
+
+	confirmed := false
+	for {
+		msg, err := pubsub.ReceiveTimeout(ctx, healthTimeout)
+		if err != nil {
+			// An idle channel is not an error, but a dead connection looks
+			// the same until we write to it.
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if err := pubsub.Ping(ctx); err != nil {
+					return confirmed, fmt.Errorf("health check: %w", err)
+				}
+				continue
+			}
+			return confirmed, err
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			confirmed = true
+			fmt.Fprintf(os.Stderr, "[%d] %s %s (mode=%s, active=%d%s)\n", ch.index, m.Kind, m.Channel, ch.mode, m.Count, shardNode(ctx, client, ch))
+		case *redis.Message:
+			fmt.Printf("%d:%s\n", ch.index, m.Payload)
+		case *redis.Pong:
+		}
+	}
+}
+
+// shardNode names the cluster master that owns a sharded channel's slot, so
+// the log shows which node each channel was routed to.
+func shardNode(ctx context.Context, client redis.UniversalClient, ch channelConfig) string {
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok || ch.mode != "sharded" {
+		return ""
+	}
+	node, err := cluster.MasterForKey(ctx, ch.name)
+	if err != nil {
+		return fmt.Sprintf(", node=unknown: %v", err)
+	}
+	return ", node=" + node.Options().Addr
+}
+
+// publish sends MESSAGE_BODY to every configured channel and prints the number
+// of subscribers that received it, as returned by PUBLISH (or SPUBLISH for
+// sharded channels).
+func publish(ctx context.Context, client redis.UniversalClient, channels []channelConfig) {
+	body := os.Getenv("MESSAGE_BODY")
+	if body == "" {
+		body = `{"tenant":"test","body":"hello"}`
+	}
+
+	for _, ch := range channels {
+		var receivers int64
+		var err error
+		switch ch.mode {
+		case "pattern":
+			log.Fatalf("Cannot publish to pattern %s; set REDIS_SUBSCRIBE_MODE=exact or sharded", ch.name)
+		case "sharded":
+			receivers, err = client.SPublish(ctx, ch.name, body).Result()
+		default:
+			receivers, err = client.Publish(ctx, ch.name, body).Result()
+		}
+		if err != nil {
+			log.Fatalf("Failed to publish to %s: %v", ch.name, err)
+		}
+		fmt.Fprintf(os.Stderr, "Published to %s (mode=%s): %s\n", ch.name, ch.mode, body)
+		fmt.Printf("%s:%d\n", ch.name, receivers)
 	}
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// streamConfig is one entry of REDIS_STREAM. index is the 1-based position in
+// the CSV and prefixes each printed entry, matching the other consumers.
+type streamConfig struct {
+	index int
+	name  string
+}
+
+func main() {
+	redisURL := getEnv("REDIS_URL", "redis://redis-main.redis-test.svc.cluster.local:6379")
+
+	streams := collectStreams(os.Getenv("REDIS_STREAM"))
+	if len(streams) == 0 {
+		log.Fatal("REDIS_STREAM must be set")
+	}
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatalf("Failed to parse REDIS_URL: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := redis.NewClient(opts)
+	defer client.Close()
+
+	if os.Getenv("PRODUCE_MODE") == "true" {
+		produce(ctx, client, streams)
+		return
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		fmt.Fprintln(os.Stderr, "Received shutdown signal")
+		cancel()
+	}()
+
+	consume(ctx, client, streams)
+}
+
+func collectStreams(csv string) []streamConfig {
+	var streams []streamConfig
+	for _, s := range strings.Split(csv, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			streams = append(streams, streamConfig{index: len(streams) + 1, name: s})
+		}
+	}
+	return streams
+}
+
+// consume reads every stream through one consumer group with XREADGROUP.
+// With REDIS_ACK=false entries are left in the pending list, which lets a
+// second consumer (or a restarted one) pick them up via XAUTOCLAIM once they
+// have been idle for REDIS_CLAIM_IDLE. Each entry is printed once per process,
+// even when XAUTOCLAIM hands this consumer's own pending entries back.
+func consume(ctx context.Context, client *redis.Client, streams []streamConfig) {
+	group := getEnv("REDIS_GROUP", "redis-streams-consumer")
+	consumer := getEnv("REDIS_CONSUMER", defaultConsumerName())
+	start := getEnv("REDIS_GROUP_START", "$")
+	ack := getEnv("REDIS_ACK", "true") == "true"
+	block := getDuration("REDIS_BLOCK", 5*time.Second)
+	count := int64(getInt("REDIS_COUNT", 10))
+	claimIdle := getDuration("REDIS_CLAIM_IDLE", 60*time.Second)
+	payloadField := getEnv("REDIS_PAYLOAD_FIELD", "data")
+
+	names := make([]string, len(streams))
+	for i, s := range streams {
+		names[i] = s.name
+	}
+	fmt.Fprintf(os.Stderr, "redis-streams-consumer starting stream=%s group=%s consumer=%s ack=%t claim_idle=%s\n",
+		strings.Join(names, ","), group, consumer, ack, claimIdle)
+
+	for _, s := range streams {
+		ensureGroup(ctx, client, s, group, start)
+	}
+
+	// XREADGROUP takes all stream names followed by one ID per stream.
+	args := make([]string, 0, 2*len(streams))
+	args = append(args, names...)
+	for range streams {
+		args = append(args, ">")
+	}
+	byName := make(map[string]streamConfig, len(streams))
+	for _, s := range streams {
+		byName[s.name] = s
+	}
+
+	// Entries left pending (REDIS_ACK=false) that were already printed; see
+	// claimStale.
+	printed := map[string]bool{}
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		if claimIdle > 0 && time.Since(lastClaim) >= claimIdle/2 {
+			for _, s := range streams {
+				claimStale(ctx, client, s, group, consumer, claimIdle, payloadField, ack, printed)
+			}
+			lastClaim = time.Now()
+		}
+
+		result, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  args,
+			Count:    count,
+			Block:    block,
+		}).Result()
+		if err != nil {
+			if err == redis.Nil || ctx.Err() != nil {
+				continue
+			}
+			// The stream or group can disappear underneath us (FLUSHALL,
+			// DEL, a test resetting state); recreate and keep going.
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				fmt.Fprintf(os.Stderr, "Consumer group missing, recreating: %v\n", err)
+				for _, s := range streams {
+					ensureGroup(ctx, client, s, group, start)
+				}
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "XREADGROUP error: %v\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, xs := range result {
+			s := byName[xs.Stream]
+			for _, msg := range xs.Messages {
+				handleEntry(ctx, client, s, group, msg, payloadField, ack, "")
+				if !ack {
+					printed[s.name+"/"+msg.ID] = true
+				}
+			}
+		}
+	}
+}
+
+// ensureGroup creates the consumer group (and the stream, via MKSTREAM) if it
+// does not exist yet. BUSYGROUP means it is already there.
+func ensureGroup(ctx context.Context, client *redis.Client, s streamConfig, group, start string) {
+	err := client.XGroupCreateMkStream(ctx, s.name, group, start).Err()
+	switch {
+	case err == nil:
+		fmt.Fprintf(os.Stderr, "[%d] created group %s on %s at %s\n", s.index, group, s.name, start)
+	case strings.HasPrefix(err.Error(), "BUSYGROUP"):
+		fmt.Fprintf(os.Stderr, "[%d] group %s already exists on %s\n", s.index, group, s.name)
+	default:
+		log.Fatalf("Failed to create group %s on %s: %v", group, s.name, err)
+	}
+}
+
+// claimStale takes over entries that another consumer read but never acked,
+// once they have been idle for at least minIdle. XAUTOCLAIM cannot leave out
+// the calling consumer, so with REDIS_ACK=false it also re-claims this
+// consumer's own pending entries (resetting their idle time); printed holds
+// the entries already handled here so those are not printed again.
+func claimStale(ctx context.Context, client *redis.Client, s streamConfig, group, consumer string, minIdle time.Duration, payloadField string, ack bool, printed map[string]bool) {
+	cursor := "0-0"
+	for ctx.Err() == nil {
+		msgs, next, err := client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.name,
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  minIdle,
+			Start:    cursor,
+			Count:    100,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "[%d] XAUTOCLAIM error on %s: %v\n", s.index, s.name, err)
+			}
+			return
+		}
+		for _, msg := range msgs {
+			if printed[s.name+"/"+msg.ID] {
+				continue
+			}
+			handleEntry(ctx, client, s, group, msg, payloadField, ack, " (claimed)")
+			if !ack {
+				printed[s.name+"/"+msg.ID] = true
+			}
+		}
+		if next == "0-0" {
+			return
+		}
+		cursor = next
+	}
+}
+
+func handleEntry(ctx context.Context, client *redis.Client, s streamConfig, group string, msg redis.XMessage, payloadField string, ack bool, note string) {
+	fmt.Fprintf(os.Stderr, "Received entry %s from stream %s%s\n", msg.ID, s.name, note)
+	fmt.Printf("%d:%s\n", s.index, entryPayload(msg, payloadField))
+
+	if !ack {
+		return
+	}
+	if err := client.XAck(ctx, s.name, group, msg.ID).Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "[%d] XACK error for %s: %v\n", s.index, msg.ID, err)
+	}
+}
+
+// entryPayload returns the configured payload field, or all fields as JSON
+// when the entry does not have it.
+func entryPayload(msg redis.XMessage, field string) string {
+	if v, ok := msg.Values[field]; ok {
+		return fmt.Sprint(v)
+	}
+	b, err := json.Marshal(msg.Values)
+	if err != nil {
+		return fmt.Sprint(msg.Values)
+	}
+	return string(b)
+}
+
+// produce XADDs MESSAGE_COUNT entries to every configured stream. The body is
+// stored in REDIS_PAYLOAD_FIELD; MESSAGE_FIELDS adds extra k=v fields that a
+// split filter can match on.
+func produce(ctx context.Context, client *redis.Client, streams []streamConfig) {
+	body := getEnv("MESSAGE_BODY", `{"tenant":"test","body":"hello"}`)
+	payloadField := getEnv("REDIS_PAYLOAD_FIELD", "data")
+	count := getInt("MESSAGE_COUNT", 1)
+	maxLen := int64(getInt("REDIS_MAXLEN", 0))
+
+	values := map[string]interface{}{payloadField: body}
+	for _, kv := range strings.Split(os.Getenv("MESSAGE_FIELDS"), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	for _, s := range streams {
+		for i := 0; i < count; i++ {
+			id, err := client.XAdd(ctx, &redis.XAddArgs{
+				Stream: s.name,
+				MaxLen: maxLen,
+				Approx: maxLen > 0,
+				Values: values,
+			}).Result()
+			if err != nil {
+				log.Fatalf("Failed to XADD to %s: %v", s.name, err)
+			}
+			fmt.Fprintf(os.Stderr, "Added to %s: id=%s fields=%s\n", s.name, id, formatFields(values))
+			fmt.Printf("%s:%s\n", s.name, id)
+		}
+	}
+}
+
+func formatFields(values map[string]interface{}) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%v", k, values[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func defaultConsumerName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "consumer-1"
+	}
+	return host
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", key, err)
+	}
+	return n
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration (e.g. 30s): %v", key, err)
+	}
+	return d
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -15,30 +16,96 @@ import (
 )
 
 const (
-	workflowType = "CheckoutWorkflow"
-	activityType = "ProcessOrder"
+	workflowType  = "CheckoutWorkflow"
+	activityType  = "ProcessOrder"
+	statusQuery   = "status"
+	confirmSignal = "confirm"
 )
 
-func CheckoutWorkflow(ctx workflow.Context, orderID string) (string, error) {
+// workerIdentity names this process in Temporal and in every activity result,
+// so a starter can tell which worker (local or cluster) ran each step.
+var workerIdentity string
+
+// CheckoutOptions is CheckoutWorkflow's optional second argument; workflows
+// started with only an order id (tctl) get the zero value.
+type CheckoutOptions struct {
+	WaitForSignal bool `json:"wait_for_signal"`
+}
+
+// ActivityRun records one ProcessOrder execution and the worker that ran it.
+type ActivityRun struct {
+	Step    string `json:"step"`
+	Result  string `json:"result"`
+	Worker  string `json:"worker"`
+	BuildID string `json:"build_id,omitempty"`
+}
+
+type CheckoutResult struct {
+	OrderID    string        `json:"order_id"`
+	Activities []ActivityRun `json:"activities"`
+	Signals    []string      `json:"signals,omitempty"`
+}
+
+// CheckoutStatus is returned by the status query. QueriedBy is the worker
+// that answered the query, which is not necessarily the one that ran the
+// workflow tasks.
+type CheckoutStatus struct {
+	Stage string `json:"stage"`
+	CheckoutResult
+	QueriedBy string `json:"queried_by"`
+}
+
+// CheckoutWorkflow processes the order. With WaitForSignal it then waits for
+// a confirm signal and runs ProcessOrder again, so the starter can see where
+// the tasks after a signal go.
+func CheckoutWorkflow(ctx workflow.Context, orderID string, opts CheckoutOptions) (CheckoutResult, error) {
 	info := workflow.GetInfo(ctx)
-	log.Printf("[WORKFLOW] workflow_id=%s workflow_type=%s order_id=%s",
-		info.WorkflowExecution.ID, info.WorkflowType.Name, orderID)
+	log.Printf("[WORKFLOW] workflow_id=%s workflow_type=%s order_id=%s worker=%s",
+		info.WorkflowExecution.ID, info.WorkflowType.Name, orderID, workerIdentity)
+
+	status := CheckoutStatus{Stage: "processing", CheckoutResult: CheckoutResult{OrderID: orderID}}
+	err := workflow.SetQueryHandler(ctx, statusQuery, func() (CheckoutStatus, error) {
+		s := status
+		s.QueriedBy = workerIdentity
+		return s, nil
+	})
+	if err != nil {
+		return CheckoutResult{}, err
+	}
 
 	ao := workflow.ActivityOptions{StartToCloseTimeout: time.Minute}
 	ctx = workflow.WithActivityOptions(ctx, ao)
 
-	var result string
-	if err := workflow.ExecuteActivity(ctx, ProcessOrder, orderID).Get(ctx, &result); err != nil {
-		return "", err
+	var run ActivityRun
+	if err := workflow.ExecuteActivity(ctx, ProcessOrder, orderID, "process").Get(ctx, &run); err != nil {
+		return CheckoutResult{}, err
+	}
+	status.Activities = append(status.Activities, run)
+
+	if opts.WaitForSignal {
+		status.Stage = "waiting_for_signal"
+		var note string
+		workflow.GetSignalChannel(ctx, confirmSignal).Receive(ctx, &note)
+		log.Printf("[SIGNAL] workflow_id=%s signal=%s note=%s worker=%s",
+			info.WorkflowExecution.ID, confirmSignal, note, workerIdentity)
+		status.Signals = append(status.Signals, note)
+		status.Stage = "confirming"
+
+		if err := workflow.ExecuteActivity(ctx, ProcessOrder, orderID, "confirm").Get(ctx, &run); err != nil {
+			return CheckoutResult{}, err
+		}
+		status.Activities = append(status.Activities, run)
 	}
-	return result, nil
+
+	status.Stage = "completed"
+	return status.CheckoutResult, nil
 }
 
-func ProcessOrder(ctx context.Context, orderID string) (string, error) {
+func ProcessOrder(ctx context.Context, orderID, step string) (ActivityRun, error) {
 	info := activity.GetInfo(ctx)
-	log.Printf("[ACTIVITY] workflow_id=%s activity_type=%s order_id=%s",
-		info.WorkflowExecution.ID, info.ActivityType.Name, orderID)
-	return "processed:" + orderID, nil
+	log.Printf("[ACTIVITY] workflow_id=%s activity_type=%s order_id=%s step=%s worker=%s build_id=%s",
+		info.WorkflowExecution.ID, info.ActivityType.Name, orderID, step, workerIdentity, workerBuildID)
+	return ActivityRun{Step: step, Result: "processed:" + orderID, Worker: workerIdentity, BuildID: workerBuildID}, nil
 }
 
 func main() {
@@ -46,44 +113,88 @@ func main() {
 	address := getEnv("TEMPORAL_ADDRESS", "localhost:7233")
 	namespace := getEnv("TEMPORAL_NAMESPACE", "temporal")
 	taskQueue := getEnv("TEMPORAL_TASK_QUEUE", "order-checkout")
+	childTaskQueue = getEnv("TEMPORAL_CHILD_TASK_QUEUE", "order-fulfillment")
+	mode := getEnv("TEMPORAL_MODE", "worker")
+	workerIdentity = getEnv("WORKER_IDENTITY", defaultIdentity(appName))
+	workerBuildID = os.Getenv("TEMPORAL_BUILD_ID")
 
-	log.Printf("Temporal worker starting")
-	log.Printf("  App:        %s", appName)
-	log.Printf("  Address:    %s", address)
-	log.Printf("  Namespace:  %s", namespace)
-	log.Printf("  Task queue: %s", taskQueue)
+	log.Printf("Temporal %s starting", mode)
+	log.Printf("  App:         %s", appName)
+	log.Printf("  Address:     %s", address)
+	log.Printf("  Namespace:   %s", namespace)
+	log.Printf("  Task queue:  %s", taskQueue)
+	log.Printf("  Child queue: %s", childTaskQueue)
+	log.Printf("  Identity:    %s", workerIdentity)
+	if workerBuildID != "" {
+		log.Printf("  Build id:    %s", workerBuildID)
+	}
 
 	c, err := client.Dial(client.Options{
 		HostPort:  address,
 		Namespace: namespace,
+		Identity:  workerIdentity,
 	})
 	if err != nil {
 		log.Fatalf("Failed to create Temporal client: %v", err)
 	}
 	defer c.Close()
 
-	w := worker.New(c, taskQueue, worker.Options{})
-	w.RegisterWorkflowWithOptions(CheckoutWorkflow, workflow.RegisterOptions{Name: workflowType})
-	w.RegisterActivityWithOptions(ProcessOrder, activity.RegisterOptions{Name: activityType})
+	switch mode {
+	case "worker":
+	case "starter":
+		code := runStarter(c, taskQueue)
+		c.Close()
+		os.Exit(code)
+	default:
+		log.Fatalf("Unknown TEMPORAL_MODE=%s (worker or starter)", mode)
+	}
+
+	specs, err := parseTaskQueues(os.Getenv("TEMPORAL_TASK_QUEUES"), taskQueue, childTaskQueue)
+	if err != nil {
+		log.Fatalf("Invalid task queues: %v", err)
+	}
+	errCh := make(chan error, 1)
+	workers, err := newWorkers(c, specs, func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to create workers: %v", err)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- w.Run(worker.InterruptCh())
-	}()
+	for i, w := range workers {
+		if err := w.Start(); err != nil {
+			log.Fatalf("Failed to start worker for %s: %v", specs[i].name, err)
+		}
+	}
 
 	select {
 	case <-ctx.Done():
 		log.Println("Shutting down worker...")
-		w.Stop()
-		<-errCh
 	case err := <-errCh:
-		if err != nil {
-			log.Fatalf("Worker failed: %v", err)
-		}
+		log.Printf("Worker failed: %v", err)
+		stopWorkers(workers)
+		os.Exit(1)
 	}
+	stopWorkers(workers)
+}
+
+func stopWorkers(workers []worker.Worker) {
+	for _, w := range workers {
+		w.Stop()
+	}
+}
+
+// defaultIdentity is <app>@<hostname>:<pid>. Local runs set WORKER_IDENTITY
+// instead, as mirrord may report the target's hostname.
+func defaultIdentity(appName string) string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s:%d", appName, host, os.Getpid())
 }
 
 func getEnv(key, fallback string) string {
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+// TEMPORAL_TASK_QUEUES runs one worker per task queue in this process. It is a
+// comma separated list of queue=set+set entries, where a set is checkout
+// (CheckoutWorkflow), long (the workflows in workflows.go), child
+// (ChildWorkflow) or all; a bare queue name gets all. ${VAR} references are
+// expanded, so each queue can still come from its own env var and be split on
+// its own by the operator:
+//
+//	TEMPORAL_TASK_QUEUES='${TEMPORAL_TASK_QUEUE}=checkout+long,${TEMPORAL_CHILD_TASK_QUEUE}=child,order-audit=checkout'
+//
+// Unset, it is TEMPORAL_TASK_QUEUE=checkout+long plus
+// TEMPORAL_CHILD_TASK_QUEUE=child, which is what the worker always ran.
+//
+// TEMPORAL_BUILD_ID stamps every worker, and every step it records, with a
+// build id. TEMPORAL_VERSIONING=on also opts the workers into worker
+// versioning, so the server only hands them tasks for compatible build ids.
+// TEMPORAL_VERSIONING_REGISTER adds the build id to each queue's version sets
+// before polling: "default" as a new default set, "compatible:<id>" as
+// compatible with an existing id (and made the default). The server needs the
+// worker versioning APIs enabled (see temporal-server.yaml).
+
+type queueSpec struct {
+	name string
+	sets []string
+}
+
+// workerSet is what one set registers on a worker.
+type workerSet struct {
+	workflows  map[string]interface{}
+	activities map[string]interface{}
+}
+
+var workerSets = map[string]workerSet{
+	"checkout": {
+		workflows:  map[string]interface{}{workflowType: CheckoutWorkflow},
+		activities: map[string]interface{}{activityType: ProcessOrder},
+	},
+	"long": {
+		workflows: map[string]interface{}{
+			signalWaitWorkflowType:    SignalWaitWorkflow,
+			timerWorkflowType:         TimerWorkflow,
+			parentWorkflowType:        ParentWorkflow,
+			continueAsNewWorkflowType: ContinueAsNewWorkflow,
+			heartbeatWorkflowType:     HeartbeatWorkflow,
+		},
+		activities: map[string]interface{}{
+			runStepActivityType:   RunStep,
+			heartbeatActivityType: HeartbeatStep,
+		},
+	},
+	"child": {
+		workflows:  map[string]interface{}{childWorkflowType: ChildWorkflow},
+		activities: map[string]interface{}{runStepActivityType: RunStep},
+	},
+}
+
+// workerBuildID is TEMPORAL_BUILD_ID, recorded next to workerIdentity.
+var workerBuildID string
+
+// parseTaskQueues reads TEMPORAL_TASK_QUEUES, or builds the default from the
+// two single-queue variables. Entries naming the same queue are merged.
+func parseTaskQueues(raw, taskQueue, childQueue string) ([]queueSpec, error) {
+	if raw == "" {
+		raw = taskQueue + "=checkout+long," + childQueue + "=child"
+	}
+	var specs []queueSpec
+	index := map[string]int{}
+	for _, entry := range strings.Split(os.ExpandEnv(raw), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, setList, hasSets := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("TEMPORAL_TASK_QUEUES entry %q has no queue name", entry)
+		}
+		sets := []string{"all"}
+		if hasSets {
+			sets = strings.Split(setList, "+")
+		}
+		for i, set := range sets {
+			sets[i] = strings.TrimSpace(set)
+			if _, ok := workerSets[sets[i]]; !ok && sets[i] != "all" {
+				return nil, fmt.Errorf("TEMPORAL_TASK_QUEUES entry %q: unknown set %q (checkout, long, child or all)", entry, sets[i])
+			}
+		}
+		if i, ok := index[name]; ok {
+			specs[i].sets = append(specs[i].sets, sets...)
+			continue
+		}
+		index[name] = len(specs)
+		specs = append(specs, queueSpec{name: name, sets: sets})
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("TEMPORAL_TASK_QUEUES lists no task queue")
+	}
+	return specs, nil
+}
+
+// register adds the workflows and activities of sets to w, once each.
+func register(w worker.Worker, sets []string) (workflows, activities []string) {
+	var names []string
+	for _, set := range sets {
+		if set == "all" {
+			names = nil
+			for name := range workerSets {
+				names = append(names, name)
+			}
+			break
+		}
+		names = append(names, set)
+	}
+	sort.Strings(names)
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		set := workerSets[name]
+		for wfName, fn := range set.workflows {
+			if !seen["wf:"+wfName] {
+				seen["wf:"+wfName] = true
+				w.RegisterWorkflowWithOptions(fn, workflow.RegisterOptions{Name: wfName})
+				workflows = append(workflows, wfName)
+			}
+		}
+		for actName, fn := range set.activities {
+			if !seen["act:"+actName] {
+				seen["act:"+actName] = true
+				w.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: actName})
+				activities = append(activities, actName)
+			}
+		}
+	}
+	sort.Strings(workflows)
+	sort.Strings(activities)
+	return workflows, activities
+}
+
+// newWorkers creates (but does not start) a worker for every queue.
+func newWorkers(c client.Client, specs []queueSpec, onFatal func(error)) ([]worker.Worker, error) {
+	versioning := getEnv("TEMPORAL_VERSIONING", "off")
+	if versioning != "on" && versioning != "off" {
+		return nil, fmt.Errorf("unknown TEMPORAL_VERSIONING=%s (on or off)", versioning)
+	}
+	if versioning == "on" && workerBuildID == "" {
+		return nil, fmt.Errorf("TEMPORAL_VERSIONING=on needs TEMPORAL_BUILD_ID")
+	}
+
+	var workers []worker.Worker
+	for _, spec := range specs {
+		if versioning == "on" {
+			if err := registerBuildID(c, spec.name, os.Getenv("TEMPORAL_VERSIONING_REGISTER")); err != nil {
+				return nil, err
+			}
+		}
+		w := worker.New(c, spec.name, worker.Options{
+			Identity:                workerIdentity,
+			BuildID:                 workerBuildID,
+			UseBuildIDForVersioning: versioning == "on",
+			OnFatalError:            onFatal,
+		})
+		workflows, activities := register(w, spec.sets)
+		log.Printf("Task queue %s: workflows=%s activities=%s build_id=%s versioning=%s",
+			spec.name, strings.Join(workflows, ","), strings.Join(activities, ","), workerBuildID, versioning)
+		workers = append(workers, w)
+	}
+	return workers, nil
+}
+
+// registerBuildID adds workerBuildID to queue's version sets as asked by
+// mode, then logs the sets.
+func registerBuildID(c client.Client, queue, mode string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := &client.UpdateWorkerBuildIdCompatibilityOptions{TaskQueue: queue}
+	switch {
+	case mode == "":
+	case mode == "default":
+		opts.Operation = &client.BuildIDOpAddNewIDInNewDefaultSet{BuildID: workerBuildID}
+	case strings.HasPrefix(mode, "compatible:"):
+		opts.Operation = &client.BuildIDOpAddNewCompatibleVersion{
+			BuildID:                   workerBuildID,
+			ExistingCompatibleBuildID: strings.TrimPrefix(mode, "compatible:"),
+			MakeSetDefault:            true,
+		}
+	default:
+		return fmt.Errorf("unknown TEMPORAL_VERSIONING_REGISTER=%s (default or compatible:<build id>)", mode)
+	}
+	if opts.Operation != nil {
+		if err := c.UpdateWorkerBuildIdCompatibility(ctx, opts); err != nil {
+			return fmt.Errorf("register build id %s on %s: %w", workerBuildID, queue, err)
+		}
+	}
+
+	sets, err := buildIDSets(ctx, c, queue)
+	if err != nil {
+		log.Printf("Could not read version sets of %s: %v", queue, err)
+		return nil
+	}
+	log.Printf("Task queue %s version sets: %v", queue, sets)
+	return nil
+}
+
+// buildIDSets returns queue's compatible version sets, the default set last.
+func buildIDSets(ctx context.Context, c client.Client, queue string) ([][]string, error) {
+	sets, err := c.GetWorkerBuildIdCompatibility(ctx, &client.GetWorkerBuildIdCompatibilityOptions{TaskQueue: queue})
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]string, 0, len(sets.Sets))
+	for _, set := range sets.Sets {
+		out = append(out, set.BuildIDs)
+	}
+	return out, nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+)
+
+// TEMPORAL_MODE=starter runs no worker. It starts STARTER_WORKFLOW executions
+// (CheckoutWorkflow by default, or any workflow in workflows.go) on
+// TEMPORAL_TASK_QUEUE, waits for them and prints a JSON report naming the
+// worker that ran each step, so a split test is driven and checked by the
+// same binary that runs the workers. STARTER_STEPS (default 3) is the number
+// of signals, timers, children, runs or heartbeats and STARTER_INTERVAL
+// (default 5s) the timer and heartbeat interval.
+//
+// The ids are STARTER_WORKFLOW_IDS (comma separated) or, when that is empty,
+// STARTER_COUNT ids of the form STARTER_ID_PREFIX<unix time>-<n>.
+// STARTER_SEARCH_ATTRIBUTES=Key=value,... sets Keyword search attributes (they
+// must be registered in the namespace) and STARTER_MEMO=key=value,... the memo.
+//
+// STARTER_SIGNAL=1 starts CheckoutWorkflows waiting for a confirm signal. The
+// starter queries each one until it is waiting, signals it and waits for the
+// second activity. SignalWaitWorkflows are always signalled that way, once per
+// step. Every workflow is queried again once it has completed.
+//
+// STARTER_EXPECT=prefix=regex,... lists, per workflow id prefix, the worker
+// identity each of its steps must match ("*" matches every id), e.g.
+// test-alice-=^local-alice$,test-other-=^temporal-worker@. Child workflow
+// steps run on another queue and are only checked with
+// STARTER_EXPECT_CHILDREN=1, for workers that split that queue too. Any
+// mismatch, failure or STARTER_TIMEOUT (default 60s) expiry exits 1.
+
+type starterExpectation struct {
+	prefix string
+	worker *regexp.Regexp
+}
+
+type starterWorkflow struct {
+	WorkflowID     string      `json:"workflow_id"`
+	RunID          string      `json:"run_id,omitempty"`
+	Result         interface{} `json:"result,omitempty"`
+	Steps          []StepRun   `json:"steps,omitempty"`
+	ChildSteps     []StepRun   `json:"child_steps,omitempty"`
+	QueriedBy      []string    `json:"queried_by,omitempty"`
+	ExpectedWorker string      `json:"expected_worker,omitempty"`
+	Mismatches     []string    `json:"mismatches,omitempty"`
+	Error          string      `json:"error,omitempty"`
+}
+
+// statusReply is the part of every status query answer the starter reads.
+type statusReply struct {
+	Stage     string `json:"stage"`
+	QueriedBy string `json:"queried_by"`
+}
+
+type starterReport struct {
+	TaskQueue  string            `json:"task_queue"`
+	BuildIDs   [][]string        `json:"build_id_sets,omitempty"`
+	Workflow   string            `json:"workflow"`
+	Signal     bool              `json:"signal"`
+	Workflows  []starterWorkflow `json:"workflows"`
+	Passed     bool              `json:"passed"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+func runStarter(c client.Client, taskQueue string) int {
+	start := time.Now()
+	kind := getEnv("STARTER_WORKFLOW", workflowType)
+	signal := isEnabled("STARTER_SIGNAL") || kind == signalWaitWorkflowType
+	report := &starterReport{TaskQueue: taskQueue, Workflow: kind, Signal: signal}
+
+	timeout, err := time.ParseDuration(getEnv("STARTER_TIMEOUT", "60s"))
+	if err != nil {
+		log.Printf("Invalid STARTER_TIMEOUT: %v", err)
+		return 1
+	}
+	interval, err := time.ParseDuration(getEnv("STARTER_INTERVAL", "5s"))
+	if err != nil {
+		log.Printf("Invalid STARTER_INTERVAL: %v", err)
+		return 1
+	}
+	steps, err := strconv.Atoi(getEnv("STARTER_STEPS", "3"))
+	if err != nil || steps < 1 {
+		log.Printf("Invalid STARTER_STEPS %q", os.Getenv("STARTER_STEPS"))
+		return 1
+	}
+	ids, err := starterIDs()
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	searchAttributes, err := parseSearchAttributes(os.Getenv("STARTER_SEARCH_ATTRIBUTES"))
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	memo, err := parsePairs("STARTER_MEMO", os.Getenv("STARTER_MEMO"))
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	expectations, err := parseExpectations(os.Getenv("STARTER_EXPECT"))
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// On a versioned queue new workflows go to the default (last) set.
+	if sets, err := buildIDSets(ctx, c, taskQueue); err == nil {
+		report.BuildIDs = sets
+	}
+
+	runs := make([]client.WorkflowRun, len(ids))
+	report.Workflows = make([]starterWorkflow, len(ids))
+	for i, id := range ids {
+		wf := &report.Workflows[i]
+		wf.WorkflowID = id
+		opts := client.StartWorkflowOptions{
+			ID:                    id,
+			TaskQueue:             taskQueue,
+			Memo:                  memo,
+			TypedSearchAttributes: searchAttributes,
+		}
+		args, err := starterArgs(kind, id, signal, steps, interval)
+		if err != nil {
+			log.Printf("%v", err)
+			return 1
+		}
+		run, err := c.ExecuteWorkflow(ctx, opts, kind, args...)
+		if err != nil {
+			wf.Error = fmt.Sprintf("start: %v", err)
+			continue
+		}
+		runs[i] = run
+		wf.RunID = run.GetRunID()
+		log.Printf("Started %s id=%s run_id=%s", kind, id, wf.RunID)
+	}
+
+	if signal {
+		for i, run := range runs {
+			if run == nil {
+				continue
+			}
+			if err := sendSignals(ctx, c, &report.Workflows[i], kind, steps); err != nil {
+				report.Workflows[i].Error = err.Error()
+				runs[i] = nil
+			}
+		}
+	}
+
+	for i, run := range runs {
+		if run == nil {
+			continue
+		}
+		wf := &report.Workflows[i]
+		if err := collectResult(ctx, run, wf, kind); err != nil {
+			wf.Error = fmt.Sprintf("result: %v", err)
+			continue
+		}
+		for _, st := range append(wf.Steps, wf.ChildSteps...) {
+			log.Printf("id=%s step=%s kind=%s worker=%s build_id=%s", wf.WorkflowID, st.Step, st.Kind, st.Worker, st.BuildID)
+		}
+		// The latest run: a continued-as-new workflow's first run is closed.
+		if _, err := queryStatus(ctx, c, wf, ""); err != nil {
+			wf.Error = err.Error()
+		}
+	}
+
+	return finishStarterReport(report, expectations, isEnabled("STARTER_EXPECT_CHILDREN"), start)
+}
+
+// awaitStage queries the workflow until it reports stage.
+func awaitStage(ctx context.Context, c client.Client, wf *starterWorkflow, stage string) error {
+	for {
+		status, err := queryStatus(ctx, c, wf, wf.RunID)
+		if err == nil && status.Stage == stage {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("still %s, want %s: %w", status.Stage, stage, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func queryStatus(ctx context.Context, c client.Client, wf *starterWorkflow, runID string) (statusReply, error) {
+	var status statusReply
+	value, err := c.QueryWorkflow(ctx, wf.WorkflowID, runID, statusQuery)
+	if err != nil {
+		return status, fmt.Errorf("query: %w", err)
+	}
+	if err := value.Get(&status); err != nil {
+		return status, fmt.Errorf("decode query result: %w", err)
+	}
+	log.Printf("Query id=%s stage=%s queried_by=%s", wf.WorkflowID, status.Stage, status.QueriedBy)
+	if n := len(wf.QueriedBy); n == 0 || wf.QueriedBy[n-1] != status.QueriedBy {
+		wf.QueriedBy = append(wf.QueriedBy, status.QueriedBy)
+	}
+	return status, nil
+}
+
+// starterArgs builds the workflow arguments for kind.
+func starterArgs(kind, id string, signal bool, steps int, interval time.Duration) ([]interface{}, error) {
+	switch kind {
+	case workflowType:
+		return []interface{}{"order-" + id, CheckoutOptions{WaitForSignal: signal}}, nil
+	case signalWaitWorkflowType:
+		return []interface{}{SignalWaitOptions{Signals: steps}}, nil
+	case timerWorkflowType:
+		return []interface{}{TimerOptions{Timers: steps, Interval: interval}}, nil
+	case parentWorkflowType:
+		return []interface{}{ParentOptions{Children: steps, TaskQueue: os.Getenv("STARTER_CHILD_TASK_QUEUE")}}, nil
+	case continueAsNewWorkflowType:
+		return []interface{}{ContinueOptions{Runs: steps}}, nil
+	case heartbeatWorkflowType:
+		return []interface{}{HeartbeatOptions{Beats: steps, Interval: interval}}, nil
+	}
+	return nil, fmt.Errorf("unknown STARTER_WORKFLOW %q", kind)
+}
+
+// sendSignals waits for the workflow to block on each signal and sends it.
+func sendSignals(ctx context.Context, c client.Client, wf *starterWorkflow, kind string, steps int) error {
+	if kind == workflowType {
+		if err := awaitStage(ctx, c, wf, "waiting_for_signal"); err != nil {
+			return err
+		}
+		note := "confirmed by " + workerIdentity
+		if err := c.SignalWorkflow(ctx, wf.WorkflowID, wf.RunID, confirmSignal, note); err != nil {
+			return fmt.Errorf("signal: %w", err)
+		}
+		log.Printf("Signalled %s id=%s", confirmSignal, wf.WorkflowID)
+		return nil
+	}
+	if kind != signalWaitWorkflowType {
+		return fmt.Errorf("%s takes no signals", kind)
+	}
+	for i := 1; i <= steps; i++ {
+		if err := awaitStage(ctx, c, wf, fmt.Sprintf("waiting_for_signal_%d", i)); err != nil {
+			return err
+		}
+		note := fmt.Sprintf("step %d from %s", i, workerIdentity)
+		if err := c.SignalWorkflow(ctx, wf.WorkflowID, wf.RunID, stepSignal, note); err != nil {
+			return fmt.Errorf("signal %d: %w", i, err)
+		}
+		log.Printf("Signalled %s %d/%d id=%s", stepSignal, i, steps, wf.WorkflowID)
+	}
+	return nil
+}
+
+// collectResult waits for the workflow (following continue-as-new) and
+// collects its steps and those of its children.
+func collectResult(ctx context.Context, run client.WorkflowRun, wf *starterWorkflow, kind string) error {
+	if kind == workflowType {
+		var result CheckoutResult
+		if err := run.Get(ctx, &result); err != nil {
+			return err
+		}
+		wf.Result = result
+		for _, a := range result.Activities {
+			wf.Steps = append(wf.Steps, StepRun{Step: a.Step, Kind: "activity", Worker: a.Worker, BuildID: a.BuildID, Result: a.Result})
+		}
+		return nil
+	}
+	var result WorkflowReport
+	if err := run.Get(ctx, &result); err != nil {
+		return err
+	}
+	wf.Result = result
+	wf.Steps = result.Steps
+	for i, child := range result.Children {
+		for _, st := range child.Steps {
+			st.Step = fmt.Sprintf("child-%d/%s", i+1, st.Step)
+			wf.ChildSteps = append(wf.ChildSteps, st)
+		}
+	}
+	return nil
+}
+
+func starterIDs() ([]string, error) {
+	if v := os.Getenv("STARTER_WORKFLOW_IDS"); v != "" {
+		var ids []string
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+	count, err := strconv.Atoi(getEnv("STARTER_COUNT", "1"))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid STARTER_COUNT %q", os.Getenv("STARTER_COUNT"))
+	}
+	prefix := getEnv("STARTER_ID_PREFIX", "test-starter-")
+	stamp := time.Now().Unix()
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s%d-%d", prefix, stamp, i+1)
+	}
+	return ids, nil
+}
+
+// parsePairs reads key=value,... into a map.
+func parsePairs(name, v string) (map[string]interface{}, error) {
+	if v == "" {
+		return nil, nil
+	}
+	out := map[string]interface{}{}
+	for _, pair := range strings.Split(v, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid %s entry %q, want key=value", name, pair)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+func parseSearchAttributes(v string) (temporal.SearchAttributes, error) {
+	pairs, err := parsePairs("STARTER_SEARCH_ATTRIBUTES", v)
+	if err != nil {
+		return temporal.SearchAttributes{}, err
+	}
+	var updates []temporal.SearchAttributeUpdate
+	for key, value := range pairs {
+		updates = append(updates, temporal.NewSearchAttributeKeyKeyword(key).ValueSet(value.(string)))
+	}
+	return temporal.NewSearchAttributes(updates...), nil
+}
+
+func parseExpectations(v string) ([]starterExpectation, error) {
+	var out []starterExpectation
+	if v == "" {
+		return out, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		prefix, pattern, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("invalid STARTER_EXPECT entry %q, want prefix=regex", entry)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("STARTER_EXPECT entry %q: %w", entry, err)
+		}
+		out = append(out, starterExpectation{prefix: prefix, worker: re})
+	}
+	return out, nil
+}
+
+// expectedWorker returns the pattern for id: the longest matching prefix, or "*".
+func expectedWorker(expectations []starterExpectation, id string) *regexp.Regexp {
+	var best *starterExpectation
+	for i, e := range expectations {
+		if e.prefix == "*" && best == nil {
+			best = &expectations[i]
+		} else if strings.HasPrefix(id, e.prefix) && (best == nil || best.prefix == "*" || len(e.prefix) > len(best.prefix)) {
+			best = &expectations[i]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.worker
+}
+
+func finishStarterReport(report *starterReport, expectations []starterExpectation, children bool, start time.Time) int {
+	report.Passed = len(report.Workflows) > 0
+	for i := range report.Workflows {
+		wf := &report.Workflows[i]
+		if wf.Error != "" || wf.Result == nil {
+			report.Passed = false
+			continue
+		}
+		re := expectedWorker(expectations, wf.WorkflowID)
+		if re == nil {
+			continue
+		}
+		wf.ExpectedWorker = re.String()
+		checked := wf.Steps
+		if children {
+			checked = append(checked, wf.ChildSteps...)
+		}
+		for _, st := range checked {
+			if !re.MatchString(st.Worker) {
+				wf.Mismatches = append(wf.Mismatches, fmt.Sprintf("%s ran on %s", st.Step, st.Worker))
+			}
+		}
+		if len(wf.Mismatches) > 0 {
+			report.Passed = false
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode report: %v", err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !report.Passed {
+		log.Println("Starter check FAILED")
+		return 1
+	}
+	log.Println("Starter check passed")
+	return 0
+}
+
+func isEnabled(key string) bool {
+	v := os.Getenv(key)
+	return v == "1" || v == "true"
+}
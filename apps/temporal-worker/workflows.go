@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Long-running workflows for queue-splitting edge cases. Each one records the
+// worker behind every step: workflow steps through a SideEffect, so a replay
+// on another worker keeps the identity of the worker that first ran the step,
+// and activity steps from the activity itself. The question they answer is
+// whether a split session keeps a workflow's later tasks once they run after a
+// signal, a timer, a child's completion, a continue-as-new or a long
+// heartbeating activity.
+//
+// All of them answer the status query with their stage and steps so far, and
+// take an options struct whose zero value (a tctl start without input) runs
+// one round with a 10s interval.
+
+const (
+	signalWaitWorkflowType    = "SignalWaitWorkflow"
+	timerWorkflowType         = "TimerWorkflow"
+	parentWorkflowType        = "ParentWorkflow"
+	childWorkflowType         = "ChildWorkflow"
+	continueAsNewWorkflowType = "ContinueAsNewWorkflow"
+	heartbeatWorkflowType     = "HeartbeatWorkflow"
+	runStepActivityType       = "RunStep"
+	heartbeatActivityType     = "HeartbeatStep"
+
+	stepSignal      = "step"
+	defaultInterval = 10 * time.Second
+)
+
+// childTaskQueue is where ParentWorkflow starts its children unless its
+// options name another queue.
+var childTaskQueue string
+
+// StepRun is one step of a workflow and the worker that ran it. Kind is
+// "workflow" for workflow task code and "activity" for activities.
+type StepRun struct {
+	Step    string `json:"step"`
+	Kind    string `json:"kind"`
+	Worker  string `json:"worker"`
+	BuildID string `json:"build_id,omitempty"`
+	Attempt int32  `json:"attempt,omitempty"`
+	Result  string `json:"result,omitempty"`
+}
+
+type WorkflowReport struct {
+	Workflow string           `json:"workflow"`
+	Steps    []StepRun        `json:"steps"`
+	Children []WorkflowReport `json:"children,omitempty"`
+}
+
+// WorkflowStatus is the status query answer of the workflows in this file.
+type WorkflowStatus struct {
+	Stage     string    `json:"stage"`
+	Steps     []StepRun `json:"steps"`
+	QueriedBy string    `json:"queried_by"`
+}
+
+type SignalWaitOptions struct {
+	Signals int `json:"signals"`
+}
+
+type TimerOptions struct {
+	Timers   int           `json:"timers"`
+	Interval time.Duration `json:"interval"`
+}
+
+type ParentOptions struct {
+	Children  int    `json:"children"`
+	TaskQueue string `json:"task_queue"`
+}
+
+type ContinueOptions struct {
+	Runs  int       `json:"runs"`
+	Run   int       `json:"run"`
+	Steps []StepRun `json:"steps,omitempty"`
+}
+
+type HeartbeatOptions struct {
+	Beats    int           `json:"beats"`
+	Interval time.Duration `json:"interval"`
+}
+
+type heartbeatProgress struct {
+	Beat   int    `json:"beat"`
+	Worker string `json:"worker"`
+}
+
+// stepLog collects a workflow's steps and serves the status query.
+type stepLog struct {
+	report WorkflowReport
+	stage  string
+}
+
+func newStepLog(ctx workflow.Context, steps []StepRun) (*stepLog, error) {
+	info := workflow.GetInfo(ctx)
+	l := &stepLog{report: WorkflowReport{Workflow: info.WorkflowType.Name, Steps: steps}, stage: "started"}
+	err := workflow.SetQueryHandler(ctx, statusQuery, func() (WorkflowStatus, error) {
+		return WorkflowStatus{Stage: l.stage, Steps: l.report.Steps, QueriedBy: workerIdentity}, nil
+	})
+	return l, err
+}
+
+// workflowStep records a step of workflow code. The worker comes from a
+// SideEffect so it is the one that first ran the step, not the one replaying.
+func (l *stepLog) workflowStep(ctx workflow.Context, step string) {
+	run := StepRun{Step: step, Kind: "workflow"}
+	encoded := workflow.SideEffect(ctx, func(workflow.Context) interface{} {
+		return StepRun{Worker: workerIdentity, BuildID: workerBuildID}
+	})
+	var ran StepRun
+	if err := encoded.Get(&ran); err != nil {
+		ran.Worker = "unknown"
+	}
+	run.Worker, run.BuildID = ran.Worker, ran.BuildID
+	if !workflow.IsReplaying(ctx) {
+		info := workflow.GetInfo(ctx)
+		log.Printf("[STEP] workflow_id=%s workflow_type=%s step=%s worker=%s build_id=%s",
+			info.WorkflowExecution.ID, info.WorkflowType.Name, step, run.Worker, run.BuildID)
+	}
+	l.stage = step
+	l.report.Steps = append(l.report.Steps, run)
+}
+
+// activityStep runs RunStep and records the worker that ran it.
+func (l *stepLog) activityStep(ctx workflow.Context, step string) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{StartToCloseTimeout: time.Minute})
+	var run StepRun
+	if err := workflow.ExecuteActivity(ctx, runStepActivityType, step).Get(ctx, &run); err != nil {
+		return err
+	}
+	l.report.Steps = append(l.report.Steps, run)
+	return nil
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func intervalOrDefault(d time.Duration) time.Duration {
+	if d <= 0 {
+		return defaultInterval
+	}
+	return d
+}
+
+// SignalWaitWorkflow blocks on the step signal opts.Signals times and runs an
+// activity after each one.
+func SignalWaitWorkflow(ctx workflow.Context, opts SignalWaitOptions) (WorkflowReport, error) {
+	l, err := newStepLog(ctx, nil)
+	if err != nil {
+		return WorkflowReport{}, err
+	}
+	l.workflowStep(ctx, "start")
+
+	ch := workflow.GetSignalChannel(ctx, stepSignal)
+	for i := 1; i <= atLeastOne(opts.Signals); i++ {
+		l.stage = fmt.Sprintf("waiting_for_signal_%d", i)
+		var note string
+		ch.Receive(ctx, &note)
+		l.workflowStep(ctx, fmt.Sprintf("signal-%d", i))
+		if err := l.activityStep(ctx, fmt.Sprintf("after-signal-%d", i)); err != nil {
+			return l.report, err
+		}
+	}
+	l.stage = "completed"
+	return l.report, nil
+}
+
+// TimerWorkflow sleeps on opts.Timers durable timers in a row and runs an
+// activity after each one fires.
+func TimerWorkflow(ctx workflow.Context, opts TimerOptions) (WorkflowReport, error) {
+	l, err := newStepLog(ctx, nil)
+	if err != nil {
+		return WorkflowReport{}, err
+	}
+	l.workflowStep(ctx, "start")
+
+	interval := intervalOrDefault(opts.Interval)
+	for i := 1; i <= atLeastOne(opts.Timers); i++ {
+		l.stage = fmt.Sprintf("timer_%d", i)
+		if err := workflow.Sleep(ctx, interval); err != nil {
+			return l.report, err
+		}
+		l.workflowStep(ctx, fmt.Sprintf("timer-%d-fired", i))
+		if err := l.activityStep(ctx, fmt.Sprintf("after-timer-%d", i)); err != nil {
+			return l.report, err
+		}
+	}
+	l.stage = "completed"
+	return l.report, nil
+}
+
+// ParentWorkflow runs opts.Children ChildWorkflows one after the other on
+// another task queue, then a step of its own after each child completes.
+func ParentWorkflow(ctx workflow.Context, opts ParentOptions) (WorkflowReport, error) {
+	l, err := newStepLog(ctx, nil)
+	if err != nil {
+		return WorkflowReport{}, err
+	}
+	l.workflowStep(ctx, "start")
+
+	// The default queue comes from the worker's environment, so it is pinned
+	// in history to keep replays on other workers deterministic.
+	queue := opts.TaskQueue
+	if queue == "" {
+		encoded := workflow.SideEffect(ctx, func(workflow.Context) interface{} { return childTaskQueue })
+		if err := encoded.Get(&queue); err != nil {
+			return l.report, err
+		}
+	}
+
+	parentID := workflow.GetInfo(ctx).WorkflowExecution.ID
+	for i := 1; i <= atLeastOne(opts.Children); i++ {
+		l.stage = fmt.Sprintf("child_%d", i)
+		cctx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+			WorkflowID: fmt.Sprintf("%s-child-%d", parentID, i),
+			TaskQueue:  queue,
+		})
+		var child WorkflowReport
+		if err := workflow.ExecuteChildWorkflow(cctx, childWorkflowType).Get(ctx, &child); err != nil {
+			return l.report, err
+		}
+		l.report.Children = append(l.report.Children, child)
+		l.workflowStep(ctx, fmt.Sprintf("child-%d-completed", i))
+		if err := l.activityStep(ctx, fmt.Sprintf("after-child-%d", i)); err != nil {
+			return l.report, err
+		}
+	}
+	l.stage = "completed"
+	return l.report, nil
+}
+
+// ChildWorkflow is started by ParentWorkflow on the child task queue.
+func ChildWorkflow(ctx workflow.Context) (WorkflowReport, error) {
+	l, err := newStepLog(ctx, nil)
+	if err != nil {
+		return WorkflowReport{}, err
+	}
+	l.workflowStep(ctx, "child-start")
+	if err := l.activityStep(ctx, "child-activity"); err != nil {
+		return l.report, err
+	}
+	l.stage = "completed"
+	return l.report, nil
+}
+
+// ContinueAsNewWorkflow runs a step and an activity, then continues as new
+// until opts.Runs runs have happened, carrying the steps along.
+func ContinueAsNewWorkflow(ctx workflow.Context, opts ContinueOptions) (WorkflowReport, error) {
+	l, err := newStepLog(ctx, opts.Steps)
+	if err != nil {
+		return WorkflowReport{}, err
+	}
+	run := opts.Run
+	if run < 1 {
+		run = 1
+	}
+	l.workflowStep(ctx, fmt.Sprintf("run-%d", run))
+	if err := l.activityStep(ctx, fmt.Sprintf("run-%d-activity", run)); err != nil {
+		return l.report, err
+	}
+	if run < atLeastOne(opts.Runs) {
+		next := ContinueOptions{Runs: opts.Runs, Run: run + 1, Steps: l.report.Steps}
+		return l.report, workflow.NewContinueAsNewError(ctx, continueAsNewWorkflowType, next)
+	}
+	l.stage = "completed"
+	return l.report, nil
+}
+
+// HeartbeatWorkflow runs one HeartbeatStep activity that beats opts.Beats
+// times, opts.Interval apart. A retry resumes from the last heartbeat.
+func HeartbeatWorkflow(ctx workflow.Context, opts HeartbeatOptions) (WorkflowReport, error) {
+	l, err := newStepLog(ctx, nil)
+	if err != nil {
+		return WorkflowReport{}, err
+	}
+	l.workflowStep(ctx, "start")
+
+	beats, interval := atLeastOne(opts.Beats), intervalOrDefault(opts.Interval)
+	actx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Duration(beats)*interval + time.Minute,
+		HeartbeatTimeout:    3 * interval,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 3},
+	})
+	l.stage = "heartbeating"
+	var run StepRun
+	if err := workflow.ExecuteActivity(actx, heartbeatActivityType, beats, interval).Get(ctx, &run); err != nil {
+		return l.report, err
+	}
+	l.report.Steps = append(l.report.Steps, run)
+	l.workflowStep(ctx, "after-heartbeat")
+	l.stage = "completed"
+	return l.report, nil
+}
+
+// RunStep is the activity behind every activity step.
+func RunStep(ctx context.Context, step string) (StepRun, error) {
+	info := activity.GetInfo(ctx)
+	log.Printf("[ACTIVITY] workflow_id=%s activity_type=%s step=%s attempt=%d worker=%s build_id=%s",
+		info.WorkflowExecution.ID, info.ActivityType.Name, step, info.Attempt, workerIdentity, workerBuildID)
+	return StepRun{Step: step, Kind: "activity", Worker: workerIdentity, BuildID: workerBuildID, Attempt: info.Attempt}, nil
+}
+
+// HeartbeatStep heartbeats beats times. Its progress travels in the heartbeat
+// details, so an attempt started elsewhere reports where it picked up.
+func HeartbeatStep(ctx context.Context, beats int, interval time.Duration) (StepRun, error) {
+	info := activity.GetInfo(ctx)
+	var from heartbeatProgress
+	if activity.HasHeartbeatDetails(ctx) {
+		if err := activity.GetHeartbeatDetails(ctx, &from); err != nil {
+			log.Printf("Ignoring unreadable heartbeat details: %v", err)
+		}
+	}
+
+	for beat := from.Beat + 1; beat <= beats; beat++ {
+		select {
+		case <-ctx.Done():
+			return StepRun{}, ctx.Err()
+		case <-time.After(interval):
+		}
+		activity.RecordHeartbeat(ctx, heartbeatProgress{Beat: beat, Worker: workerIdentity})
+		log.Printf("[HEARTBEAT] workflow_id=%s beat=%d/%d attempt=%d worker=%s",
+			info.WorkflowExecution.ID, beat, beats, info.Attempt, workerIdentity)
+	}
+
+	result := fmt.Sprintf("%d beats", beats)
+	if from.Worker != "" {
+		result += fmt.Sprintf(", resumed after beat %d from %s", from.Beat, from.Worker)
+	}
+	return StepRun{
+		Step: "heartbeat", Kind: "activity", Worker: workerIdentity, BuildID: workerBuildID,
+		Attempt: info.Attempt, Result: result,
+	}, nil
+}